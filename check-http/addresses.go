@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// lookupIPAddr resolves the addresses of a host, it can be replaced by the
+// tests
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// dialFunc is the signature of the dial function of a transport
+type dialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// hostPort returns the host and port of a URL, with the default port of its
+// scheme when it has none
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// checkAddresses resolves the host of the URL, unless --resolve provides its
+// addresses, and checks every address, while the requests keep the URL, hence
+// its Host header and SNI
+func (c *CheckHTTP) checkAddresses() error {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return &plugin.Exit{Msg: "Invalid request: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	resolves, err := c.resolveOverrides()
+	if err != nil {
+		return err
+	}
+
+	var ips []string
+	host, port, _ := net.SplitHostPort(hostPort(u))
+	for _, o := range resolves {
+		if o.matches(host, port) {
+			ips = o.addresses
+			break
+		}
+	}
+
+	if ips == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+
+		addrs, err := lookupIPAddr(ctx, host)
+		if err != nil {
+			return &plugin.Exit{Msg: fmt.Sprintf("could not resolve %s: %s", host, err), Status: plugin.Critical}
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.String())
+		}
+	}
+
+	seen := map[string]bool{}
+	var addresses []string
+	for _, address := range ips {
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return &plugin.Exit{Msg: fmt.Sprintf("could not resolve %s: no address found", host), Status: plugin.Critical}
+	}
+
+	outcomes, err := c.checkConcurrently(len(addresses), func(i int) outcome {
+		return c.checkCopy(func(ac *CheckHTTP) {
+			ac.address = addresses[i]
+			ac.allAddresses = false
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	labels := make([]string, len(addresses))
+	for i, address := range addresses {
+		labels[i] = "addr_" + sanitizeLabel(address)
+	}
+	return c.combineExits("addresses", addresses, labels, outcomes)
+}
+
+// dialAddress returns a dial function that connects to the checked address
+// instead of resolving the host of the URL. The connections to other hosts,
+// e.g. after a redirection, are left untouched
+func (c *CheckHTTP) dialAddress(dial dialFunc) (dialFunc, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, &plugin.Exit{Msg: "Invalid request: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	target := hostPort(u)
+	_, port, _ := net.SplitHostPort(target)
+	address := net.JoinHostPort(c.address, port)
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.EqualFold(addr, target) {
+			addr = address
+		}
+		return dial(ctx, network, addr)
+	}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunAllAddresses(t *testing.T) {
+	// Several backends listen on the same port of distinct loopback
+	// addresses, the last one is down
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request keeps the host of the URL
+		if host, _, _ := net.SplitHostPort(r.Host); !strings.HasSuffix(host, ".test") {
+			w.WriteHeader(http.StatusMisdirectedRequest)
+			return
+		}
+		w.Write([]byte("healthy"))
+	}))
+	defer healthy.Close()
+	_, port, _ := net.SplitHostPort(healthy.Listener.Addr().String())
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		t.Skipf("could not listen on 127.0.0.2: %s", err)
+	}
+	failing := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	failing.Listener.Close()
+	failing.Listener = listener
+	failing.Start()
+	defer failing.Close()
+
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) {
+		lookupIPAddr = lookup
+	}(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "service.test":
+			return []net.IPAddr{
+				{IP: net.ParseIP("127.0.0.1")},
+				{IP: net.ParseIP("127.0.0.2")},
+				{IP: net.ParseIP("127.0.0.3")},
+				{IP: net.ParseIP("127.0.0.1")},
+			}, nil
+		case "healthy.test":
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		args       []string
+		wantStatus int
+		wantMsgs   []string
+	}{
+		{
+			name:       "Every address",
+			host:       "service.test",
+			wantStatus: plugin.Critical,
+			wantMsgs: []string{
+				"CRITICAL: 1/3 addresses OK, 2 critical\n",
+				"\nOK: addr_127.0.0.1 127.0.0.1 - 200 OK",
+				"\nCRITICAL: addr_127.0.0.2 127.0.0.2 - 503 Service Unavailable",
+				"\nCRITICAL: addr_127.0.0.3 127.0.0.3 - ",
+				" addr_127.0.0.1_status_code=200",
+			},
+		},
+		{
+			name:       "Failures below the quorum",
+			host:       "service.test",
+			args:       []string{"--warning-failures", "1", "--critical-failures", "2"},
+			wantStatus: plugin.Warning,
+			wantMsgs:   []string{"WARNING: 1/3 addresses OK, 2 critical, more than 1 failed\n", "failed=2;1;2;0;3"},
+		},
+		{
+			name:       "Single address",
+			host:       "healthy.test",
+			wantStatus: plugin.OK,
+			wantMsgs:   []string{"OK: 1/1 addresses OK\nOK: addr_127.0.0.1 127.0.0.1 - 200 OK"},
+		},
+		{
+			name:       "Unknown host",
+			host:       "missing.test",
+			wantStatus: plugin.Critical,
+			wantMsgs:   []string{"could not resolve missing.test: no such host"},
+		},
+		{
+			name:       "Proxy",
+			host:       "service.test",
+			args:       []string{"--proxy", "http://proxy.test:3128"},
+			wantStatus: plugin.Unknown,
+			wantMsgs:   []string{"--all-addresses can not be used with --proxy"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCheckHTTP()
+			args := append([]string{"--all-addresses", "-u", "http://" + net.JoinHostPort(tt.host, port) + "/"}, tt.args...)
+			if err := c.cmd.ParseFlags(args); err != nil {
+				t.Fatal(err)
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			for _, msg := range tt.wantMsgs {
+				if exit != nil && !strings.Contains(exit.Error(), msg) {
+					t.Errorf("exit = %q, want %q", exit.Error(), msg)
+				}
+			}
+		})
+	}
+}
+
+func TestRunAllAddressesTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "Test CA")
+	cert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "service.test"},
+		DNSNames:    []string{"service.test"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, nil)
+
+	var mu sync.Mutex
+	var serverNames []string
+	config := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			serverNames = append(serverNames, hello.ServerName)
+			return nil, nil
+		},
+	}
+	ts := newTestTLSServer(t, func(w http.ResponseWriter, r *http.Request) {}, config, cert)
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) {
+		lookupIPAddr = lookup
+	}(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	c := newCheckHTTP()
+	args := []string{
+		"--all-addresses",
+		"--cacert", writeTempFile(t, dir, "ca.pem", ca.certPEM),
+		"-u", "https://" + net.JoinHostPort("service.test", port) + "/",
+	}
+	if err := c.cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+
+	exit := c.Run()
+	verifyExitCode(t, exit, plugin.OK)
+	if len(serverNames) != 1 || serverNames[0] != "service.test" {
+		t.Errorf("server names = %q, want [\"service.test\"]", serverNames)
+	}
+}
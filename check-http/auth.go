@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// resolveSecret returns the value of a secret provided as "env:NAME", read
+// from an environment variable, as "file:PATH", read from a file, or as is
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		b, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		// Ignore the line break that ends most files
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces the secrets of the configuration that are read from
+// an environment variable or a file by their value
+func (c *CheckHTTP) resolveSecrets() error {
+	for _, secret := range []struct {
+		flag  string
+		value *string
+	}{
+		{"--password", &c.password},
+		{"--bearer-token", &c.bearerToken},
+		{"--hmac-secret", &c.hmacSecret},
+		{"--login-data", &c.loginData},
+		{"--oauth2-client-secret", &c.oauth2ClientSecret},
+	} {
+		value, err := resolveSecret(*secret.value)
+		if err != nil {
+			return &plugin.Exit{
+				Msg:    fmt.Sprintf("could not read %s: %s", secret.flag, err),
+				Status: plugin.Unknown,
+			}
+		}
+		*secret.value = value
+	}
+	return nil
+}
+
+// setAuthorization adds the credentials to the request. Basic credentials are
+// sent right away, unless only Digest authentication is allowed or they are
+// only sent in answer to a challenge of the server, see authTransport
+func (c *CheckHTTP) setAuthorization(req *http.Request) {
+	switch {
+	case c.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	case c.user != "" && !c.digest && !c.basicOnChallenge:
+		req.SetBasicAuth(c.user, c.password)
+	}
+}
+
+// digestAlgorithms are the supported Digest algorithms, by name, strongest
+// first
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SHA-512-256", sha512.New512_256},
+	{"SHA-256", sha256.New},
+	{"MD5", md5.New},
+}
+
+// digestChallenge is a Digest authentication challenge sent by the server in
+// a WWW-Authenticate header, as described by RFC 7616
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	session   bool
+	qop       string
+	userhash  bool
+	hash      func() hash.Hash
+}
+
+// parseDigestChallenge returns the strongest supported Digest challenge among
+// the WWW-Authenticate headers, and whether one was found
+func parseDigestChallenge(headers []string) (digestChallenge, bool) {
+	best, bestRank := digestChallenge{}, len(digestAlgorithms)
+	for _, header := range headers {
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Digest ") {
+			continue
+		}
+		params := parseAuthParams(header[7:])
+
+		algorithm := params["algorithm"]
+		if algorithm == "" {
+			algorithm = "MD5"
+		}
+		name := strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")
+
+		// Only the "auth" quality of protection is supported, but servers
+		// that predate RFC 2617 do not send any
+		qop := ""
+		if params["qop"] != "" {
+			for _, value := range strings.Split(params["qop"], ",") {
+				if strings.TrimSpace(value) == "auth" {
+					qop = "auth"
+				}
+			}
+			if qop == "" {
+				continue
+			}
+		}
+
+		for rank, supported := range digestAlgorithms {
+			if supported.name != name || rank >= bestRank {
+				continue
+			}
+			best, bestRank = digestChallenge{
+				realm:     params["realm"],
+				nonce:     params["nonce"],
+				opaque:    params["opaque"],
+				algorithm: algorithm,
+				session:   strings.HasSuffix(strings.ToUpper(algorithm), "-SESS"),
+				qop:       qop,
+				userhash:  strings.EqualFold(params["userhash"], "true"),
+				hash:      supported.hash,
+			}, rank
+		}
+	}
+
+	return best, bestRank < len(digestAlgorithms)
+}
+
+// parseAuthParams parses the comma-separated "name=value" parameters of a
+// challenge, where values can be quoted strings
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return params
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			s = s[1:]
+			for len(s) > 0 && s[0] != '"' {
+				if s[0] == '\\' && len(s) > 1 {
+					s = s[1:]
+				}
+				value.WriteByte(s[0])
+				s = s[1:]
+			}
+			if len(s) > 0 {
+				s = s[1:]
+			}
+		} else {
+			end := strings.Index(s, ",")
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:end]))
+			s = s[end:]
+		}
+		params[name] = value.String()
+	}
+}
+
+// authorization returns the Authorization header that answers the challenge
+// for the request, using the provided client nonce
+func (d digestChallenge) authorization(method, uri, user, password, cnonce string) string {
+	h := func(s string) string {
+		sum := d.hash()
+		io.WriteString(sum, s)
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	const nc = "00000001"
+
+	ha1 := h(user + ":" + d.realm + ":" + password)
+	if d.session {
+		ha1 = h(ha1 + ":" + d.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if d.qop != "" {
+		response = h(strings.Join([]string{ha1, d.nonce, nc, cnonce, d.qop, ha2}, ":"))
+	} else {
+		response = h(ha1 + ":" + d.nonce + ":" + ha2)
+	}
+
+	username := user
+	if d.userhash {
+		username = h(user + ":" + d.realm)
+	}
+
+	params := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", d.realm),
+		fmt.Sprintf("uri=%q", uri),
+		"algorithm=" + d.algorithm,
+		fmt.Sprintf("nonce=%q", d.nonce),
+	}
+	if d.qop != "" {
+		params = append(params, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce), "qop="+d.qop)
+	}
+	params = append(params, fmt.Sprintf("response=%q", response))
+	if d.opaque != "" {
+		params = append(params, fmt.Sprintf("opaque=%q", d.opaque))
+	}
+	if d.userhash {
+		params = append(params, "userhash=true")
+	}
+
+	return "Digest " + strings.Join(params, ", ")
+}
+
+// hasBasicChallenge returns whether the server accepts Basic authentication
+func hasBasicChallenge(headers []string) bool {
+	for _, header := range headers {
+		if len(header) >= 5 && strings.EqualFold(header[:5], "Basic") {
+			return true
+		}
+	}
+	return false
+}
+
+// originalHost returns the host of the request that started the redirection
+// chain of the request
+func originalHost(req *http.Request) string {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL.Host
+}
+
+// authTransport answers the Digest or Basic challenges of the server by
+// sending the request again with the credentials. Basic challenges are only
+// answered when the credentials were not already sent with the request. Only
+// the challenges of the requested host are answered, so the credentials never
+// leak to another host after a redirection
+type authTransport struct {
+	transport  http.RoundTripper
+	user       string
+	password   string
+	digestOnly bool
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.URL.Host != originalHost(req) {
+		return resp, err
+	}
+
+	challenges := resp.Header.Values("WWW-Authenticate")
+	challenge, digest := parseDigestChallenge(challenges)
+	if !digest && (t.digestOnly || !hasBasicChallenge(challenges) || req.Header.Get("Authorization") != "") {
+		return resp, nil
+	}
+
+	// The body of the request was consumed and must be rewound
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+
+	if digest {
+		cnonce := make([]byte, 16)
+		if _, err := rand.Read(cnonce); err != nil {
+			return nil, err
+		}
+		retry.Header.Set("Authorization", challenge.authorization(req.Method, req.URL.RequestURI(), t.user, t.password, hex.EncodeToString(cnonce)))
+	} else {
+		retry.SetBasicAuth(t.user, t.password)
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return t.transport.RoundTrip(retry)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CHECK_HTTP_TEST_SECRET", "from-env")
+	defer os.Unsetenv("CHECK_HTTP_TEST_SECRET")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "literal", want: "literal"},
+		{value: "env:CHECK_HTTP_TEST_SECRET", want: "from-env"},
+		{value: "file:" + file, want: "from-file"},
+		{value: "env:CHECK_HTTP_TEST_MISSING", wantErr: true},
+		{value: "file:" + filepath.Join(dir, "missing"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := resolveSecret(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	// Example of RFC 7616, section 3.9.1
+	tests := []struct {
+		algorithm    string
+		wantResponse string
+	}{
+		{algorithm: "MD5", wantResponse: `response="8ca523f5e9506fed4657c9700eebdbec"`},
+		{algorithm: "SHA-256", wantResponse: `response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			challenge, ok := parseDigestChallenge([]string{
+				`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=` + tt.algorithm +
+					`, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+			})
+			if !ok {
+				t.Fatal("no challenge found")
+			}
+
+			got := challenge.authorization("GET", "/dir/index.html", "Mufasa", "Circle of Life",
+				"f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+			if !strings.Contains(got, tt.wantResponse) {
+				t.Errorf("authorization() = %q, want %q", got, tt.wantResponse)
+			}
+			if !strings.Contains(got, `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`) {
+				t.Errorf("authorization() = %q, want the opaque value", got)
+			}
+		})
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	tests := []struct {
+		name          string
+		headers       []string
+		wantAlgorithm string
+		wantOK        bool
+	}{
+		{
+			name:          "Strongest algorithm",
+			headers:       []string{`Digest realm="a", nonce="1", algorithm=MD5, qop="auth"`, `Digest realm="a", nonce="2", algorithm=SHA-256, qop="auth"`},
+			wantAlgorithm: "SHA-256",
+			wantOK:        true,
+		},
+		{
+			name:          "Default algorithm without qop",
+			headers:       []string{`Digest realm="a", nonce="1"`},
+			wantAlgorithm: "MD5",
+			wantOK:        true,
+		},
+		{
+			name:          "Session algorithm",
+			headers:       []string{`Digest realm="a", nonce="1", algorithm=SHA-256-sess, qop=auth`},
+			wantAlgorithm: "SHA-256-sess",
+			wantOK:        true,
+		},
+		{
+			name:    "Unsupported quality of protection",
+			headers: []string{`Digest realm="a", nonce="1", qop="auth-int"`},
+		},
+		{
+			name:    "Basic challenge",
+			headers: []string{`Basic realm="a"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, ok := parseDigestChallenge(tt.headers)
+			if ok != tt.wantOK {
+				t.Fatalf("parseDigestChallenge() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if challenge.algorithm != tt.wantAlgorithm {
+				t.Errorf("parseDigestChallenge() algorithm = %q, want %q", challenge.algorithm, tt.wantAlgorithm)
+			}
+		})
+	}
+}
+
+func TestRunAuthentication(t *testing.T) {
+	const nonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/basic", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "s3cret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "s3cret" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	mux.HandleFunc("/bearer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/digest", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			if strings.HasPrefix(auth, "Basic ") && r.URL.Query().Get("strict") != "" {
+				t.Error("the password was sent with Basic authentication")
+			}
+			w.Header().Add("WWW-Authenticate", `Digest realm="admin", nonce="`+nonce+`", algorithm=SHA-256, qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Compute the expected response with the nonce of the client
+		params := parseAuthParams(auth[7:])
+		challenge, _ := parseDigestChallenge([]string{`Digest realm="admin", nonce="` + nonce + `", algorithm=SHA-256, qop="auth"`})
+		want := challenge.authorization(r.Method, r.URL.RequestURI(), "admin", "s3cret", params["cnonce"])
+		if auth != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	os.Setenv("CHECK_HTTP_TEST_TOKEN", "t0ken")
+	defer os.Unsetenv("CHECK_HTTP_TEST_TOKEN")
+
+	type fields struct {
+		basicOnChallenge bool
+		bearerToken      string
+		data             string
+		digest           bool
+		minSize          int64
+		password         string
+		user             string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		path       string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Basic",
+			fields:     fields{user: "admin", password: "s3cret"},
+			path:       "/basic",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Basic with a wrong password",
+			fields:     fields{user: "admin", password: "wrong"},
+			path:       "/basic",
+			wantStatus: plugin.Critical,
+			wantMsg:    "401 Unauthorized",
+		},
+		{
+			name:       "Basic without a challenge",
+			fields:     fields{user: "admin", password: "s3cret"},
+			path:       "/forbidden",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Basic only on challenge, without a challenge",
+			fields:     fields{user: "admin", password: "s3cret", basicOnChallenge: true},
+			path:       "/forbidden",
+			wantStatus: plugin.Critical,
+			wantMsg:    "403 Forbidden",
+		},
+		{
+			name:       "Bearer token from the environment",
+			fields:     fields{bearerToken: "env:CHECK_HTTP_TEST_TOKEN"},
+			path:       "/bearer",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Missing bearer token",
+			fields:     fields{bearerToken: "env:CHECK_HTTP_TEST_MISSING"},
+			path:       "/bearer",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "could not read --bearer-token",
+		},
+		{
+			name:       "Digest challenge",
+			fields:     fields{user: "admin", password: "s3cret"},
+			path:       "/digest",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Digest only, with a request body",
+			fields:     fields{user: "admin", password: "s3cret", digest: true, data: "payload", minSize: 1},
+			path:       "/digest?strict=1",
+			wantStatus: plugin.OK,
+			wantMsg:    "7 bytes",
+		},
+		{
+			name:       "Digest with a wrong password",
+			fields:     fields{user: "admin", password: "wrong"},
+			path:       "/digest",
+			wantStatus: plugin.Critical,
+			wantMsg:    "401 Unauthorized",
+		},
+		{
+			name:       "Basic and bearer",
+			fields:     fields{user: "admin", bearerToken: "t0ken"},
+			path:       "/basic",
+			wantStatus: plugin.Unknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				basicOnChallenge: tt.fields.basicOnChallenge,
+				bearerToken:      tt.fields.bearerToken,
+				data:             tt.fields.data,
+				digest:           tt.fields.digest,
+				method:           http.MethodPost,
+				minSize:          tt.fields.minSize,
+				password:         tt.fields.password,
+				timeout:          time.Second,
+				url:              ts.URL + tt.path,
+				user:             tt.fields.user,
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	var authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "s3cret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name               string
+		basicOnChallenge   bool
+		digest             bool
+		scenario           bool
+		wantStatus         int
+		wantAuthorizations []string
+	}{
+		{
+			name:               "Basic sent right away",
+			wantStatus:         plugin.OK,
+			wantAuthorizations: []string{"Basic YWRtaW46czNjcmV0"},
+		},
+		{
+			name:               "Basic after the challenge",
+			basicOnChallenge:   true,
+			wantStatus:         plugin.OK,
+			wantAuthorizations: []string{"", "Basic YWRtaW46czNjcmV0"},
+		},
+		{
+			name:               "Basic challenge with --digest",
+			digest:             true,
+			wantStatus:         plugin.Critical,
+			wantAuthorizations: []string{""},
+		},
+		{
+			name:               "Absolute URL of a scenario step",
+			basicOnChallenge:   true,
+			scenario:           true,
+			wantStatus:         plugin.OK,
+			wantAuthorizations: []string{"", "Basic YWRtaW46czNjcmV0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorizations = nil
+			c := &CheckHTTP{
+				basicOnChallenge: tt.basicOnChallenge,
+				digest:           tt.digest,
+				password:         "s3cret",
+				timeout:          time.Second,
+				url:              ts.URL,
+				user:             "admin",
+			}
+			if tt.scenario {
+				dir, err := ioutil.TempDir("", "check-http")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+
+				c.url = ""
+				c.scenarioFile = writeTempFile(t, dir, "scenario.json", []byte(`{"steps": [{"url": "`+ts.URL+`/"}]}`))
+			}
+
+			verifyExitCode(t, c.Run(), tt.wantStatus)
+			if !reflect.DeepEqual(authorizations, tt.wantAuthorizations) {
+				t.Errorf("Authorization headers = %q, want %q", authorizations, tt.wantAuthorizations)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// weakSignatureAlgorithms are the signature algorithms considered insecure
+var weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// verifyCertificates inspects the certificate chain presented by the server
+// and returns an exit listing every problem found, or nil if the chain is
+// healthy
+func (c *CheckHTTP) verifyCertificates(resp *http.Response) error {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return &plugin.Exit{Msg: "no certificate presented, the connection does not use TLS", Status: plugin.Critical}
+	}
+	certs := resp.TLS.PeerCertificates
+
+	r := &result{}
+
+	now := time.Now()
+	for _, cert := range certs {
+		name := certificateName(cert)
+
+		// Verify the expiration of the certificate
+		days := int(cert.NotAfter.Sub(now).Hours() / 24)
+		switch {
+		case !now.Before(cert.NotAfter):
+			r.fail(plugin.Critical, "certificate %s expired on %s", name, cert.NotAfter.UTC().Format("2006-01-02"))
+		case c.criticalDays > 0 && days < c.criticalDays:
+			r.fail(plugin.Critical, "certificate %s expires in %d days", name, days)
+		case c.warningDays > 0 && days < c.warningDays:
+			r.fail(plugin.Warning, "certificate %s expires in %d days", name, days)
+		}
+
+		// The signature of a self-signed certificate is never verified
+		if !isSelfSigned(cert) && weakSignatureAlgorithms[cert.SignatureAlgorithm] {
+			r.fail(plugin.Critical, "certificate %s uses the weak signature algorithm %s", name, cert.SignatureAlgorithm)
+		}
+
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && c.minKeySize > 0 && key.N.BitLen() < c.minKeySize {
+			r.fail(plugin.Warning, "certificate %s has a %d-bit RSA key", name, key.N.BitLen())
+		}
+	}
+
+	if missing := c.missingIntermediate(certs); missing != nil {
+		r.fail(plugin.Critical, "server did not send the issuer of certificate %s (%s)",
+			certificateName(missing), missing.Issuer.String())
+	}
+
+	if r.status == plugin.OK {
+		return nil
+	}
+
+	return &plugin.Exit{Msg: strings.Join(r.failures, ", "), Status: r.status}
+}
+
+// missingIntermediate walks the chain presented by the server and returns the
+// first certificate whose issuer is neither part of the chain nor a trusted
+// root, or nil if the chain is complete. The roots are the ones used to verify
+// the server, so nothing can be said of a chain that was not verified
+func (c *CheckHTTP) missingIntermediate(certs []*x509.Certificate) *x509.Certificate {
+	if c.insecure {
+		return nil
+	}
+
+	cert := certs[0]
+	for i := 0; i < len(certs) && !isSelfSigned(cert); i++ {
+		issuer := findIssuer(cert, certs)
+		if issuer == nil {
+			break
+		}
+		cert = issuer
+	}
+
+	if isSelfSigned(cert) {
+		return nil
+	}
+
+	// The last certificate of the chain must be issued by a trusted root
+	roots, err := c.rootCAs()
+	if err != nil {
+		return nil
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: cert.NotBefore.Add(time.Second),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if _, ok := err.(x509.UnknownAuthorityError); ok {
+		return cert
+	}
+
+	return nil
+}
+
+// rootCAs returns the CA certificates used to verify the server
+func (c *CheckHTTP) rootCAs() (*x509.CertPool, error) {
+	if c.caCert != "" {
+		return loadCertPool(c.caCert)
+	}
+	return x509.SystemCertPool()
+}
+
+// findIssuer returns the certificate among the candidates that issued the
+// provided certificate. Names and key identifiers are compared instead of
+// signatures, so chains using weak algorithms can still be walked
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if candidate == cert || !bytes.Equal(cert.RawIssuer, candidate.RawSubject) {
+			continue
+		}
+		if len(cert.AuthorityKeyId) > 0 && len(candidate.SubjectKeyId) > 0 &&
+			!bytes.Equal(cert.AuthorityKeyId, candidate.SubjectKeyId) {
+			continue
+		}
+		return candidate
+	}
+	return nil
+}
+
+// isSelfSigned returns whether the certificate is its own issuer
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return len(cert.AuthorityKeyId) == 0 || bytes.Equal(cert.AuthorityKeyId, cert.SubjectKeyId)
+}
+
+// certificateName returns a short name that identifies the certificate
+func certificateName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return fmt.Sprintf("%q", cert.Subject.CommonName)
+	}
+	return fmt.Sprintf("%q", cert.Subject.String())
+}
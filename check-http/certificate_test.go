@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// newTestIntermediate generates an intermediate CA signed by the parent
+func newTestIntermediate(t *testing.T, parent *testCert, notAfter time.Time) *testCert {
+	t.Helper()
+
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		NotAfter:              notAfter,
+	}, parent, nil)
+}
+
+func TestVerifyCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := newTestCA(t, "Test Root")
+	caFile := writeTempFile(t, dir, "ca.pem", root.certPEM)
+	intermediate := newTestIntermediate(t, root, time.Time{})
+	leaf := newTestServerCert(t, intermediate, time.Time{})
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaIntermediate := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "RSA Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root, rsaKey)
+	sha1Leaf := newTestCert(t, &x509.Certificate{
+		Subject:            pkix.Name{CommonName: "sha1.example.com"},
+		SignatureAlgorithm: x509.SHA1WithRSA,
+	}, rsaIntermediate, nil)
+
+	expiringIntermediate := newTestIntermediate(t, root, time.Now().Add(20*24*time.Hour+time.Hour))
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallKeyLeaf := newTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "small.example.com"},
+	}, intermediate, smallKey)
+
+	tests := []struct {
+		name       string
+		chain      []*testCert
+		insecure   bool
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Healthy chain",
+			chain:      []*testCert{leaf, intermediate},
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Leaf expires within the critical threshold",
+			chain:      []*testCert{newTestServerCert(t, intermediate, time.Now().Add(5*24*time.Hour+time.Hour)), intermediate},
+			wantStatus: plugin.Critical,
+			wantMsg:    `certificate "localhost" expires in 5 days`,
+		},
+		{
+			name:       "Intermediate expires within the warning threshold",
+			chain:      []*testCert{newTestServerCert(t, expiringIntermediate, time.Time{}), expiringIntermediate},
+			wantStatus: plugin.Warning,
+			wantMsg:    `certificate "Test Intermediate" expires in 20 days`,
+		},
+		{
+			name:       "Expired leaf",
+			chain:      []*testCert{newTestServerCert(t, intermediate, time.Now().Add(-48*time.Hour)), intermediate},
+			wantStatus: plugin.Critical,
+			wantMsg:    `certificate "localhost" expired on`,
+		},
+		{
+			name:       "Weak signature algorithm",
+			chain:      []*testCert{sha1Leaf, rsaIntermediate},
+			wantStatus: plugin.Critical,
+			wantMsg:    `certificate "sha1.example.com" uses the weak signature algorithm SHA1-RSA`,
+		},
+		{
+			name:       "Small RSA key",
+			chain:      []*testCert{smallKeyLeaf, intermediate},
+			wantStatus: plugin.Warning,
+			wantMsg:    `certificate "small.example.com" has a 1024-bit RSA key`,
+		},
+		{
+			name:       "Missing intermediate",
+			chain:      []*testCert{leaf},
+			wantStatus: plugin.Critical,
+			wantMsg:    `server did not send the issuer of certificate "localhost" (CN=Test Intermediate)`,
+		},
+		{
+			name:       "Private CA without verification",
+			chain:      []*testCert{leaf, intermediate},
+			insecure:   true,
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Self-signed without verification",
+			chain:      []*testCert{newTestCA(t, "localhost")},
+			insecure:   true,
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "No TLS",
+			wantStatus: plugin.Critical,
+			wantMsg:    "the connection does not use TLS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				caCert:       caFile,
+				criticalDays: 7,
+				minKeySize:   2048,
+				warningDays:  30,
+			}
+			// Without verification, there is no trust store
+			if tt.insecure {
+				c.caCert = ""
+				c.insecure = true
+			}
+
+			resp := &http.Response{}
+			if tt.chain != nil {
+				resp.TLS = &tls.ConnectionState{}
+				for _, cert := range tt.chain {
+					resp.TLS.PeerCertificates = append(resp.TLS.PeerCertificates, cert.cert)
+				}
+			}
+
+			err := c.verifyCertificates(resp)
+			if tt.wantStatus == plugin.OK {
+				if err != nil {
+					t.Errorf("CheckHTTP.verifyCertificates() = %v, want nil", err)
+				}
+				return
+			}
+
+			verifyExitCode(t, err, tt.wantStatus)
+			if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("CheckHTTP.verifyCertificates() = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRunCheckCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := newTestCA(t, "Test Root")
+	caFile := writeTempFile(t, dir, "ca.pem", root.certPEM)
+	intermediate := newTestIntermediate(t, root, time.Now().Add(10*24*time.Hour))
+	leaf := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, intermediate, nil)
+
+	ts := newTestTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}, nil, leaf, intermediate)
+	defer ts.Close()
+
+	c := &CheckHTTP{
+		caCert:       caFile,
+		checkCert:    true,
+		criticalDays: 7,
+		timeout:      time.Second,
+		url:          ts.URL,
+		warningDays:  30,
+	}
+	err = c.Run()
+	verifyExitCode(t, err, plugin.Warning)
+	if err == nil || !strings.Contains(err.Error(), `certificate "Test Intermediate" expires in`) {
+		t.Errorf("CheckHTTP.Run() = %v", err)
+	}
+
+	// The certificate does not hide a failing service
+	c.url = ts.URL + "/down"
+	err = c.Run()
+	verifyExitCode(t, err, plugin.Critical)
+	for _, msg := range []string{"503 Service Unavailable", `certificate "Test Intermediate" expires in`, "status_code=503"} {
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("CheckHTTP.Run() = %v, want %q", err, msg)
+		}
+	}
+
+	// Without the inspection, only the response is verified
+	c.checkCert = false
+	c.url = ts.URL
+	verifyExitCode(t, c.Run(), plugin.OK)
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,30 +21,223 @@ import (
 type CheckHTTP struct {
 	cmd plugin.Command
 
-	missingPattern string
-	pattern        string
-	redirectOK     bool
-	responseCode   int
-	timeout        int
-	url            string
+	address             string
+	allAddresses        bool
+	allowCrossDomain    bool
+	awsProfile          string
+	awsRegion           string
+	awsService          string
+	basicOnChallenge    bool
+	bearerToken         string
+	caCert              string
+	cert                string
+	checkCert           bool
+	checksum            string
+	checksumURL         string
+	concurrency         int
+	configFile          string
+	connectTo           []string
+	contentType         string
+	cookieJar           string
+	cookies             bool
+	criticalDays        int
+	criticalFailures    int
+	criticalTime        time.Duration
+	data                string
+	dataFile            string
+	digest              bool
+	expectLocation      string
+	expectURL           string
+	followRedirects     bool
+	headerCritical      []string
+	headers             []string
+	headerWarning       []string
+	hmacAlgorithm       string
+	hmacEncoding        string
+	hmacHeader          string
+	hmacPrefix          string
+	hmacSecret          string
+	hmacTemplate        string
+	hmacTimestampHeader string
+	ignoreCase          bool
+	ignoreProxyEnv      bool
+	insecure            bool
+	integrity           string
+	jar                 *persistentJar
+	jsonCritical        []string
+	jsonExpect          []string
+	jsonPaths           []string
+	jsonWarning         []string
+	key                 string
+	keyPassword         string
+	loginContentType    string
+	loginData           string
+	loginURL            string
+	maxBody             int64
+	maxRedirects        int
+	maxSize             int64
+	method              string
+	metrics             []perfData
+	minCount            int
+	minKeySize          int
+	minSize             int64
+	missingPatterns     []string
+	noProxy             []string
+	oauth2Audience      string
+	oauth2CacheDir      string
+	oauth2ClientID      string
+	oauth2ClientSecret  string
+	oauth2Scopes        []string
+	oauth2TokenURL      string
+	password            string
+	patterns            []string
+	phaseCritical       phaseThresholds
+	phaseWarning        phaseThresholds
+	proxy               string
+	proxyUser           string
+	redirectOK          bool
+	regex               bool
+	requireHTTPS        bool
+	resolve             []string
+	responseCode        int
+	scenarioFile        string
+	serverName          string
+	sessionCookie       string
+	showVersion         bool
+	statusMapping       statusMapping
+	timeout             time.Duration
+	url                 string
+	urlFile             string
+	urls                []string
+	user                string
+	userAgent           string
+	warningDays         int
+	warningFailures     int
+	warningMaxSize      int64
+	warningMinSize      int64
+	warningTime         time.Duration
 }
 
+// version is the version of the plugin, which can be overridden at build time
+// with -ldflags "-X main.version=x.y.z"
+var version = "dev"
+
+// stdin is the reader used when the request body is read from the standard
+// input
+var stdin io.Reader = os.Stdin
+
 func main() {
 	// Initialize our check
+	c := newCheckHTTP()
+
+	// Execute the check
+	plugin.Execute(c)
+}
+
+// newCheckHTTP returns the check with its configuration flags and its
+// subcommands
+func newCheckHTTP() *CheckHTTP {
 	c := &CheckHTTP{
 		cmd: plugin.NewCommand("CheckHTTP"),
 	}
 
 	// Instantiate the configuration flags
-	c.cmd.Flags().StringVarP(&c.missingPattern, "negquery", "n", "", "Query for pattern that must be absent in response body")
-	c.cmd.Flags().StringVarP(&c.pattern, "query", "q", "", "Query for pattern that must exist in response body")
+	c.cmd.Flags().BoolVar(&c.allAddresses, "all-addresses", false, "Resolve the host of the URL and check every address, with the Host header and SNI of the URL")
+	c.cmd.Flags().BoolVar(&c.allowCrossDomain, "allow-cross-domain", false, "Follow redirections to other domains than the one of the previous URL")
+	c.cmd.Flags().StringVar(&c.awsProfile, "aws-profile", "", "Profile of the AWS shared credentials file, when the credentials are not set in the environment (default $AWS_PROFILE or \"default\")")
+	c.cmd.Flags().StringVar(&c.awsRegion, "aws-region", "", "AWS region of the signature (default $AWS_REGION)")
+	c.cmd.Flags().StringVar(&c.awsService, "aws-service", "", "Sign the request with AWS Signature Version 4 for this service, e.g. execute-api, s3 or es")
+	c.cmd.Flags().BoolVar(&c.basicOnChallenge, "basic-on-challenge", false, "Only send the password of --user with Basic authentication in answer to a challenge of the server")
+	c.cmd.Flags().StringVar(&c.bearerToken, "bearer-token", "", "Bearer token sent in the Authorization header, or env:NAME or file:PATH to read it from an environment variable or a file")
+	c.cmd.Flags().StringVar(&c.caCert, "cacert", "", "PEM file, or directory of PEM files, with the CA certificates used to verify the server")
+	c.cmd.Flags().StringVar(&c.cert, "cert", "", "Client certificate for mutual TLS, either a PEM file or a PKCS#12 bundle")
+	c.cmd.Flags().BoolVar(&c.checkCert, "check-certificate", false, "Inspect the certificate chain presented by the server")
+	c.cmd.Flags().StringVar(&c.checksum, "checksum", "", "Expected hexadecimal checksum of the response body, optionally prefixed by sha256:, sha384: or sha512:")
+	c.cmd.Flags().StringVar(&c.checksumURL, "checksum-url", "", "URL of a file containing the expected checksum of the response body, or a suffix such as .sha256 appended to --url")
+	c.cmd.Flags().IntVar(&c.concurrency, "concurrency", 10, "Maximum number of URLs or addresses checked simultaneously")
+	c.cmd.Flags().StringVar(&c.configFile, "config", "", "YAML or JSON file with the configuration of the check, whose keys are the names of the flags, which override them")
+	c.cmd.Flags().StringArrayVar(&c.connectTo, "connect-to", nil, "Connect to HOST2:PORT2 instead of HOST1:PORT1, in the \"HOST1:PORT1:HOST2:PORT2\" format where an empty part matches any host or port, or keeps it, while the Host header, SNI and certificate verification still use the URL (can be repeated)")
+	c.cmd.Flags().StringVar(&c.contentType, "content-type", "", "Content-Type of the request body (default \"application/x-www-form-urlencoded\" when a body is sent)")
+	c.cmd.Flags().StringVar(&c.cookieJar, "cookie-jar", "", "File where the cookies are saved, so sessions are reused by the next runs")
+	c.cmd.Flags().BoolVar(&c.cookies, "cookies", false, "Store the cookies set by the server and send them back with the following requests")
+	c.cmd.Flags().IntVar(&c.criticalDays, "critical-days", 7, "Critical if a certificate of the chain expires within this number of days")
+	c.cmd.Flags().IntVar(&c.criticalFailures, "critical-failures", -1, "Critical if more than this number of URLs or addresses fail, instead of reporting the worst status (-1 to disable)")
+	c.cmd.Flags().Var(newDurationValue(&c.criticalTime, 0), "critical-time", "Critical if the response time exceeds this duration (e.g. 2s)")
+	c.cmd.Flags().StringVarP(&c.data, "data", "d", "", "Request body to send")
+	c.cmd.Flags().StringVar(&c.dataFile, "data-file", "", "File containing the request body to send, or - to read it from stdin")
+	c.cmd.Flags().BoolVar(&c.digest, "digest", false, "Only use Digest authentication, never send the password with Basic authentication")
+	c.cmd.Flags().StringVar(&c.expectLocation, "expect-location", "", "Expected Location header of the first redirection")
+	c.cmd.Flags().StringVar(&c.expectURL, "expect-url", "", "Expected final URL, after following the redirections")
+	c.cmd.Flags().BoolVarP(&c.followRedirects, "follow-redirects", "L", false, "Follow redirections and verify the final response")
+	c.cmd.Flags().StringArrayVar(&c.headerCritical, "response-header", nil, "Critical unless the response header satisfies \"NAME exists\", \"NAME absent\" or \"NAME OPERATOR VALUE\" with ==, =~ or !~, e.g. \"Content-Type == application/json\" (can be repeated)")
+	c.cmd.Flags().StringArrayVarP(&c.headers, "header", "H", nil, "Request header, in the \"Name: value\" format (can be repeated)")
+	c.cmd.Flags().StringArrayVar(&c.headerWarning, "response-header-warning", nil, "Warning unless the response header satisfies the assertion, e.g. \"Server !~ [0-9]\" (can be repeated)")
+	c.cmd.Flags().StringVar(&c.hmacAlgorithm, "hmac-algorithm", "sha256", "Hash algorithm of the HMAC signature: sha1, sha256, sha384 or sha512")
+	c.cmd.Flags().StringVar(&c.hmacEncoding, "hmac-encoding", "hex", "Encoding of the HMAC signature: hex or base64")
+	c.cmd.Flags().StringVar(&c.hmacHeader, "hmac-header", "X-Signature", "Header of the HMAC signature")
+	c.cmd.Flags().StringVar(&c.hmacPrefix, "hmac-prefix", "", "Prefix of the HMAC signature in its header, e.g. \"sha256=\"")
+	c.cmd.Flags().StringVar(&c.hmacSecret, "hmac-secret", "", "Sign the request with an HMAC of this secret, or env:NAME or file:PATH to read it from an environment variable or a file")
+	c.cmd.Flags().StringVar(&c.hmacTemplate, "hmac-template", "{body}", "Template of the signed string, with the {method}, {path}, {query}, {timestamp} and {body} placeholders, e.g. \"{timestamp}.{body}\"")
+	c.cmd.Flags().StringVar(&c.hmacTimestampHeader, "hmac-timestamp-header", "", "Header of the Unix timestamp used in the HMAC signature")
+	c.cmd.Flags().BoolVarP(&c.ignoreCase, "ignore-case", "i", false, "Match the patterns of --query and --negquery case-insensitively")
+	c.cmd.Flags().BoolVar(&c.ignoreProxyEnv, "ignore-proxy-env", false, "Ignore the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables")
+	c.cmd.Flags().BoolVarP(&c.insecure, "insecure", "k", false, "Allow insecure server certificates")
+	c.cmd.Flags().StringVar(&c.integrity, "integrity", "", "Expected Subresource Integrity of the response body, e.g. sha384-...")
+	c.cmd.Flags().StringArrayVar(&c.jsonCritical, "json-critical", nil, "Critical if the JSON response meets the \"PATH OPERATOR VALUE\" condition, e.g. \"$.db.latency_ms > 200\" (can be repeated)")
+	c.cmd.Flags().StringArrayVar(&c.jsonExpect, "expect", nil, "Expected value of the matching --jsonpath (can be repeated)")
+	c.cmd.Flags().StringArrayVar(&c.jsonPaths, "jsonpath", nil, "JSON path, e.g. \"$.status\", that must exist in the JSON response (can be repeated)")
+	c.cmd.Flags().StringArrayVar(&c.jsonWarning, "json-warning", nil, "Warning if the JSON response meets the \"PATH OPERATOR VALUE\" condition (can be repeated)")
+	c.cmd.Flags().StringVar(&c.key, "key", "", "PEM private key of the client certificate, if not included in --cert")
+	c.cmd.Flags().StringVar(&c.keyPassword, "key-password", "", "Password of the encrypted private key or PKCS#12 bundle")
+	c.cmd.Flags().StringVar(&c.loginContentType, "login-content-type", "application/x-www-form-urlencoded", "Content-Type of --login-data")
+	c.cmd.Flags().StringVar(&c.loginData, "login-data", "", "Credentials posted to --login-url, e.g. \"username=sensu&password=secret\", or env:NAME or file:PATH to read them from an environment variable or a file")
+	c.cmd.Flags().StringVar(&c.loginURL, "login-url", "", "URL of a login form to post --login-data to before the check, which then uses the session cookies")
+	c.cmd.Flags().Var(newSizeValue(&c.maxBody, 0), "max-body", "Maximum size of the response body to read, e.g. 32MB, the reading stops and the check is critical as soon as it is exceeded (0 for no limit)")
+	c.cmd.Flags().Var(newSizeValue(&c.maxSize, 0), "max-size", "Critical if the response body is larger than this size, e.g. 512KB, the reading stops as soon as it is exceeded")
+	c.cmd.Flags().StringVarP(&c.method, "method", "X", http.MethodGet, "HTTP method of the request")
+	c.cmd.Flags().IntVar(&c.maxRedirects, "max-redirects", 10, "Maximum number of redirections to follow")
+	c.cmd.Flags().IntVar(&c.minCount, "min-count", 1, "Minimum number of times each pattern of --query must appear in response body")
+	c.cmd.Flags().IntVar(&c.minKeySize, "min-key-size", 2048, "Warning if a certificate of the chain has an RSA key smaller than this number of bits")
+	c.cmd.Flags().Var(newSizeValue(&c.minSize, 0), "min-size", "Critical if the response body is smaller than this size, e.g. 1KB")
+	c.cmd.Flags().StringArrayVarP(&c.missingPatterns, "negquery", "n", nil, "Query for pattern that must be absent in response body (can be repeated)")
+	c.cmd.Flags().StringSliceVar(&c.noProxy, "no-proxy", nil, "Comma-separated list of hosts, domains and CIDR ranges that bypass the proxy")
+	c.cmd.Flags().StringVar(&c.oauth2Audience, "oauth2-audience", "", "Audience of the OAuth2 access token, for the providers that require it")
+	c.cmd.Flags().StringVar(&c.oauth2CacheDir, "oauth2-cache-dir", os.TempDir(), "Directory where the OAuth2 access token is cached until it expires, empty to disable the cache")
+	c.cmd.Flags().StringVar(&c.oauth2ClientID, "oauth2-client-id", "", "OAuth2 client ID")
+	c.cmd.Flags().StringVar(&c.oauth2ClientSecret, "oauth2-client-secret", "", "OAuth2 client secret, or env:NAME or file:PATH to read it from an environment variable or a file")
+	c.cmd.Flags().StringSliceVar(&c.oauth2Scopes, "oauth2-scope", nil, "Scope of the OAuth2 access token (can be repeated)")
+	c.cmd.Flags().StringVar(&c.oauth2TokenURL, "oauth2-token-url", "", "OAuth2 token endpoint, to send the request with an access token obtained with the client credentials grant")
+	c.cmd.Flags().StringVar(&c.password, "password", "", "Password of --user, or env:NAME or file:PATH to read it from an environment variable or a file")
+	c.cmd.Flags().StringArrayVarP(&c.patterns, "query", "q", nil, "Query for pattern that must exist in response body (can be repeated)")
+	c.cmd.Flags().Var(&c.phaseCritical, "phase-critical", "Critical if a phase of the request (dns, connect, tls, server or transfer) exceeds a duration, e.g. \"tls=1s\" (can be repeated)")
+	c.cmd.Flags().Var(&c.phaseWarning, "phase-warning", "Warning if a phase of the request exceeds a duration, e.g. \"server=500ms\" (can be repeated)")
+	c.cmd.Flags().StringVar(&c.proxy, "proxy", "", "Proxy URL (http://, https:// or socks5://) to send the request through")
+	c.cmd.Flags().StringVar(&c.proxyUser, "proxy-user", "", "Proxy credentials, in the \"username:password\" format")
 	c.cmd.Flags().BoolVarP(&c.redirectOK, "redirect-ok", "r", false, "Accept redirection")
+	c.cmd.Flags().BoolVarP(&c.regex, "regex", "e", false, "Interpret the patterns of --query and --negquery as regular expressions")
+	c.cmd.Flags().BoolVar(&c.requireHTTPS, "require-https", false, "Critical if a redirection, or the final URL, does not use HTTPS")
+	c.cmd.Flags().StringArrayVar(&c.resolve, "resolve", nil, "Connect to these addresses instead of resolving the host and port, in the \"HOST:PORT:ADDRESS[,ADDRESS...]\" format where HOST can be *, while the Host header, SNI and certificate verification still use the URL (can be repeated)")
 	c.cmd.Flags().IntVar(&c.responseCode, "response-code", http.StatusOK, "Expected HTTP status code")
-	c.cmd.Flags().IntVarP(&c.timeout, "timeout", "t", 15, "Time limit, in seconds, for the request")
-	c.cmd.Flags().StringVarP(&c.url, "url", "u", "", "URL to connect to")
+	c.cmd.Flags().StringVar(&c.scenarioFile, "scenario", "", "JSON file of a multi-step scenario, whose steps can capture values for the following ones")
+	c.cmd.Flags().StringVar(&c.serverName, "sni", "", "Server name used for SNI and certificate verification, instead of the URL host")
+	c.cmd.Flags().StringVar(&c.serverName, "server-name", "", "Alias of --sni")
+	c.cmd.Flags().StringVar(&c.sessionCookie, "session-cookie", "", "Name of the session cookie created by --login-url, by default any cookie saved for the URL is considered a session")
+	c.cmd.Flags().Var(&c.statusMapping, "status", "Plugin status of HTTP status codes, e.g. \"200-204,304=ok;429=warning;5xx=critical\", unmatched codes follow --response-code and --redirect-ok (can be repeated)")
+	c.cmd.Flags().VarP(newDurationValue(&c.timeout, 15*time.Second), "timeout", "t", "Time limit for the request, as a duration (e.g. 10s) or a number of seconds")
+	c.cmd.Flags().StringArrayVarP(&c.urls, "url", "u", nil, "URL to connect to (can be repeated, to check several URLs concurrently)")
+	c.cmd.Flags().StringVar(&c.urlFile, "url-file", "", "File listing URLs to check, one per line, in addition to --url")
+	c.cmd.Flags().StringVar(&c.user, "user", "", "Username for Basic or Digest authentication")
+	c.cmd.Flags().StringVar(&c.userAgent, "user-agent", defaultUserAgent(), "User-Agent header of the request")
+	c.cmd.Flags().BoolVar(&c.showVersion, "version", false, "Print the version of the plugin")
+	c.cmd.Flags().IntVar(&c.warningDays, "warning-days", 30, "Warning if a certificate of the chain expires within this number of days")
+	c.cmd.Flags().IntVar(&c.warningFailures, "warning-failures", -1, "Warning if more than this number of URLs or addresses fail, instead of reporting the worst status (-1 to disable)")
+	c.cmd.Flags().Var(newSizeValue(&c.warningMaxSize, 0), "warning-max-size", "Warning if the response body is larger than this size")
+	c.cmd.Flags().Var(newSizeValue(&c.warningMinSize, 0), "warning-min-size", "Warning if the response body is smaller than this size")
+	c.cmd.Flags().Var(newDurationValue(&c.warningTime, 0), "warning-time", "Warning if the response time exceeds this duration (e.g. 750ms)")
 
-	// Execute the check
-	plugin.Execute(c)
+	c.cmd.AddCommand(c.validateCommand())
+
+	return c
 }
 
 // Command returns the plugin command
@@ -48,142 +247,908 @@ func (c *CheckHTTP) Command() plugin.Command {
 
 // Run executes the plugin
 func (c *CheckHTTP) Run() error {
+	return withPerfData(c.run(), c.metrics)
+}
+
+// run performs the checks and records their metrics
+func (c *CheckHTTP) run() error {
+	// The version is reported as the output of the check, since the plugin
+	// framework requires an exit for every execution
+	if c.showVersion {
+		return &plugin.Exit{Msg: "version " + version, Status: plugin.OK}
+	}
+
+	// Load the configuration file, whose values are overridden by the flags
+	if c.configFile != "" {
+		if err := c.loadConfig(c.configFile); err != nil {
+			return err
+		}
+	}
+
+	if err := c.loadURLs(); err != nil {
+		return err
+	}
+
 	// Validate the provided configuration
-	if c.url == "" {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	if len(c.urls) > 1 {
+		return c.checkURLs()
+	}
+	if len(c.urls) == 1 {
+		c.url = c.urls[0]
+	}
+
+	if c.allAddresses {
+		return c.checkAddresses()
+	}
+
+	return c.check()
+}
+
+// check performs the check of a single URL, or of the scenario
+func (c *CheckHTTP) check() error {
+	var s *scenario
+	if c.scenarioFile != "" {
+		var err error
+		if s, err = loadScenario(c.scenarioFile); err != nil {
+			return err
+		}
+	}
+
+	if err := c.resolveSecrets(); err != nil {
+		return err
+	}
+
+	// Perform the request
+	client, err := c.prepareClient()
+	if err != nil {
+		return err
+	}
+
+	if c.oauth2TokenURL != "" {
+		if c.bearerToken, err = c.oauth2AccessToken(client); err != nil {
+			return err
+		}
+	}
+
+	// Log in first, unless a session was restored from the cookie jar
+	defer c.saveCookies(client)
+	loggedIn := false
+	if c.loginURL != "" && !c.hasSession(client) {
+		if err := c.login(client); err != nil {
+			return err
+		}
+		loggedIn = true
+	}
+
+	// The checksum may be protected like the checked URL
+	if c.checksumURL != "" {
+		if err := c.fetchChecksum(client); err != nil {
+			return err
+		}
+	}
+
+	if s != nil {
+		return c.runScenario(client, s)
+	}
+
+	resp, timings, err := c.initiateRequest(client)
+	if err != nil {
+		return err
+	}
+
+	// The restored session may have expired on the server
+	if c.loginURL != "" && !loggedIn && c.sessionExpired(resp) {
+		if err := c.login(client); err != nil {
+			return err
+		}
+		if resp, timings, err = c.initiateRequest(client); err != nil {
+			return err
+		}
+	}
+
+	exit := c.verifyRedirects(resp)
+	if exit == nil {
+		exit = describeRedirects(c.handleResponse(resp), resp)
+	}
+
+	// The certificate chain is inspected along with the response, so a
+	// certificate about to expire does not hide a failing service
+	if c.checkCert {
+		exit = joinExits(exit, c.verifyCertificates(resp))
+	}
+
+	c.metrics = c.perfData(resp, timings)
+
+	return c.gradeResponseTime(exit, timings)
+}
+
+// validate verifies that the configuration is consistent
+func (c *CheckHTTP) validate() error {
+	if len(c.urls) == 0 && c.url == "" && c.scenarioFile == "" {
 		return &plugin.Exit{Msg: "no URL specified", Status: plugin.Unknown}
 	}
 
-	if c.pattern != "" && c.missingPattern != "" {
+	if len(c.urls) > 1 && c.scenarioFile != "" {
+		return &plugin.Exit{Msg: "--scenario can not be used with several URLs", Status: plugin.Unknown}
+	}
+
+	// The steps have their own request and assertions, which replace the
+	// global ones, and the assertions of a single response can not apply to
+	// them
+	if c.scenarioFile != "" {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"--method", c.method != "" && !strings.EqualFold(c.method, http.MethodGet)},
+			{"--data", c.data != ""},
+			{"--data-file", c.dataFile != ""},
+			{"--content-type", c.contentType != ""},
+			{"--status", len(c.statusMapping) > 0},
+			{"--response-code", c.responseCode != 0 && c.responseCode != http.StatusOK},
+			{"--redirect-ok", c.redirectOK},
+			{"--query", len(c.patterns) > 0},
+			{"--negquery", len(c.missingPatterns) > 0},
+			{"--regex", c.regex},
+			{"--ignore-case", c.ignoreCase},
+			{"--jsonpath", len(c.jsonPaths) > 0},
+			{"--expect", len(c.jsonExpect) > 0},
+			{"--json-warning", len(c.jsonWarning) > 0},
+			{"--json-critical", len(c.jsonCritical) > 0},
+			{"--response-header", len(c.headerCritical) > 0},
+			{"--response-header-warning", len(c.headerWarning) > 0},
+			{"--check-certificate", c.checkCert},
+			{"--phase-warning", len(c.phaseWarning) > 0},
+			{"--phase-critical", len(c.phaseCritical) > 0},
+			{"--min-size", c.minSize > 0},
+			{"--max-size", c.maxSize > 0},
+			{"--warning-min-size", c.warningMinSize > 0},
+			{"--warning-max-size", c.warningMaxSize > 0},
+			{"--checksum", c.checksum != ""},
+			{"--checksum-url", c.checksumURL != ""},
+			{"--integrity", c.integrity != ""},
+			{"--expect-url", c.expectURL != ""},
+			{"--expect-location", c.expectLocation != ""},
+		} {
+			if f.set {
+				return &plugin.Exit{Msg: f.name + " can not be used with --scenario", Status: plugin.Unknown}
+			}
+		}
+	}
+
+	if c.allAddresses {
+		switch {
+		case len(c.urls) > 1:
+			return &plugin.Exit{Msg: "--all-addresses can not be used with several URLs", Status: plugin.Unknown}
+		case c.scenarioFile != "":
+			return &plugin.Exit{Msg: "--all-addresses can not be used with --scenario", Status: plugin.Unknown}
+		case c.proxy != "":
+			return &plugin.Exit{Msg: "--all-addresses can not be used with --proxy", Status: plugin.Unknown}
+		case len(c.connectTo) > 0:
+			return &plugin.Exit{Msg: "--all-addresses can not be used with --connect-to", Status: plugin.Unknown}
+		}
+	}
+
+	if (len(c.connectTo) > 0 || len(c.resolve) > 0) && c.proxy != "" {
+		return &plugin.Exit{Msg: "--connect-to and --resolve can not be used with --proxy", Status: plugin.Unknown}
+	}
+
+	if (len(c.urls) > 1 || c.allAddresses) && c.concurrency < 1 {
+		return &plugin.Exit{Msg: "--concurrency must be at least 1", Status: plugin.Unknown}
+	}
+
+	if c.data != "" && c.dataFile != "" {
 		return &plugin.Exit{
-			Msg:    "--query and --negquery can not be used simultaneously",
+			Msg:    "--data and --data-file can not be used simultaneously",
 			Status: plugin.Unknown,
 		}
 	}
 
-	// Perform the request
-	client := c.prepareClient()
-	resp, err := c.initiateRequest(client)
-	if err != nil {
+	if len(c.jsonExpect) > 0 && len(c.jsonExpect) != len(c.jsonPaths) {
+		return &plugin.Exit{
+			Msg:    "--expect must be provided once for every --jsonpath",
+			Status: plugin.Unknown,
+		}
+	}
+
+	authMethods := 0
+	for _, method := range []string{c.user, c.bearerToken, c.oauth2TokenURL, c.awsService} {
+		if method != "" {
+			authMethods++
+		}
+	}
+	if authMethods > 1 {
+		return &plugin.Exit{
+			Msg:    "--user, --bearer-token, --oauth2-token-url and --aws-service can not be used simultaneously",
+			Status: plugin.Unknown,
+		}
+	}
+
+	if c.oauth2TokenURL != "" && c.oauth2ClientID == "" {
+		return &plugin.Exit{Msg: "--oauth2-client-id is required with --oauth2-token-url", Status: plugin.Unknown}
+	}
+
+	integritySources := 0
+	for _, source := range []string{c.checksum, c.checksumURL, c.integrity} {
+		if source != "" {
+			integritySources++
+		}
+	}
+	if integritySources > 1 {
+		return &plugin.Exit{
+			Msg:    "--checksum, --checksum-url and --integrity can not be used simultaneously",
+			Status: plugin.Unknown,
+		}
+	}
+
+	return nil
+}
+
+// perfData returns the metrics of the response
+func (c *CheckHTTP) perfData(resp *http.Response, t *timings) []perfData {
+	metrics := []perfData{
+		timePerfData("time", t.total(), c.warningTime, c.criticalTime),
+	}
+	for _, phase := range phases {
+		metrics = append(metrics, timePerfData("time_"+phase, t.phase(phase), c.phaseWarning[phase], c.phaseCritical[phase]))
+	}
+
+	return append(metrics,
+		perfData{label: "size", value: float64(resp.ContentLength), unit: "B", min: "0"},
+		perfData{label: "status_code", value: float64(resp.StatusCode)},
+	)
+}
+
+// timePerfData returns the metric of a duration, in seconds, at the full
+// resolution of the duration so the fast phases of a local request are not
+// reported as 0s
+func timePerfData(label string, d, warning, critical time.Duration) perfData {
+	p := perfData{
+		label: label,
+		value: d.Seconds(),
+		unit:  "s",
+		min:   "0",
+	}
+	if warning > 0 {
+		p.warning = formatFloat(warning.Seconds())
+	}
+	if critical > 0 {
+		p.critical = formatFloat(critical.Seconds())
+	}
+	return p
+}
+
+// gradeResponseTime adds the response time, and the duration of its phases,
+// to the exit and raises its status when the time or a phase exceeds its
+// warning or critical threshold
+func (c *CheckHTTP) gradeResponseTime(err error, t *timings) error {
+	exit, ok := err.(*plugin.Exit)
+	if !ok {
 		return err
 	}
 
-	return c.handleResponse(resp)
+	r := &result{status: exit.Status}
+	grade := func(name string, d, warning, critical time.Duration) {
+		switch {
+		case critical > 0 && d > critical:
+			r.fail(plugin.Critical, "%s %s exceeds %s", name, formatDuration(d), formatDuration(critical))
+		case warning > 0 && d > warning:
+			r.fail(plugin.Warning, "%s %s exceeds %s", name, formatDuration(d), formatDuration(warning))
+		}
+	}
+
+	grade("response time", t.total(), c.warningTime, c.criticalTime)
+	failures := len(r.failures)
+	for _, phase := range phases {
+		grade(phase, t.phase(phase), c.phaseWarning[phase], c.phaseCritical[phase])
+	}
+
+	// The response time is always reported, followed by its phases
+	msg := exit.Msg
+	if failures > 0 {
+		msg += ", " + r.failures[0]
+	} else {
+		msg += ", response time " + formatDuration(t.total())
+	}
+	if phases := t.String(); phases != "" {
+		msg += " (" + phases + ")"
+	}
+	for _, failure := range r.failures[failures:] {
+		msg += ", " + failure
+	}
+
+	exit.Msg = msg
+	exit.Status = r.status
+	return exit
 }
 
 func (c *CheckHTTP) handleResponse(resp *http.Response) error {
 	responseCode := statusLine(resp.StatusCode)
 
+	status := c.responseStatus(resp.StatusCode)
+	if status == plugin.OK {
+		// The response code is healthy, now verify the response headers and
+		// body, and report all their failures together
+		r := &result{}
+		c.verifyHeaders(resp.Header, r)
+		return c.verifyBody(resp, r)
+	}
+
+	// Codes matched by --status are reported as is
+	if _, ok := c.statusMapping.lookup(resp.StatusCode); ok {
+		return &plugin.Exit{Msg: responseCode, Status: status}
+	}
+
 	// Verify if we are expecting something else than a 200 OK status
 	if c.responseCode != http.StatusOK && c.responseCode != 0 {
-		if c.responseCode == resp.StatusCode {
-			// The response code corresponds to the expected one, now verify the
-			// response body
-			return c.verifyBody(resp)
-		}
 		return &plugin.Exit{
 			Msg:    fmt.Sprintf("expected HTTP status %s, got %s", statusLine(c.responseCode), responseCode),
-			Status: plugin.Critical,
+			Status: status,
 		}
 	}
 
-	if resp.StatusCode >= http.StatusOK && resp.StatusCode <= http.StatusIMUsed {
-		// Verify the response body
-		return c.verifyBody(resp)
-	} else if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode <= http.StatusPermanentRedirect {
-		// ~300
-		if c.redirectOK {
-			// The redirection was expected, now verify the response body
-			return c.verifyBody(resp)
-		}
-
+	if resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode < http.StatusBadRequest {
 		// A redirection was not expected
 		return &plugin.Exit{
 			Msg:    responseCode + ": unexpected redirection",
-			Status: plugin.Warning,
+			Status: status,
 		}
 	}
 
-	return &plugin.Exit{Msg: responseCode, Status: plugin.Critical}
+	return &plugin.Exit{Msg: responseCode, Status: status}
 }
 
-func (c *CheckHTTP) initiateRequest(client *http.Client) (*http.Response, error) {
-	resp, err := client.Get(c.url)
+func (c *CheckHTTP) initiateRequest(client *http.Client) (*http.Response, *timings, error) {
+	resp, t, err := c.sendRequest(client)
 	if err != nil {
-		// If we have an error, verify if it's a timeout
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, &plugin.Exit{
-				Msg:    fmt.Sprintf("Request exceeded timeout of %d seconds", c.timeout),
-				Status: plugin.Critical,
-			}
+		return nil, nil, err
+	}
+
+	// Read the whole body right away, so the transfer is part of the request
+	// and subject to its timeout. Stop early when the body exceeds the limit,
+	// so a huge or endless response can not exhaust the memory or the time of
+	// the check. The announced length is only trusted when a body follows,
+	// unlike the response to a HEAD request
+	defer resp.Body.Close()
+	if c.maxBody > 0 && resp.ContentLength > c.maxBody && hasBody(resp.Request, resp) {
+		return nil, nil, c.maxBodyError()
+	}
+
+	body, err := c.readBody(resp.Body)
+	if _, ok := err.(*plugin.Exit); ok {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, c.requestError(err)
+	}
+	t.done = time.Now()
+	resp.Body = body
+	resp.ContentLength = body.size
+
+	return resp, t, nil
+}
+
+// sendRequest builds, signs and sends the request, and returns the response
+// whose body is left to read
+func (c *CheckHTTP) sendRequest(client *http.Client) (*http.Response, *timings, error) {
+	req, err := c.newRequest()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Sign the request last, once its headers are final. The HMAC signature
+	// is part of the headers signed by AWS
+	if c.hmacSecret != "" {
+		if err := c.signHMAC(req); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.awsService != "" {
+		if err := c.signSigV4(req); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Record the timings of every phase of the request
+	t := &timings{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.trace()))
+
+	t.start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, c.requestError(err)
+	}
+	return resp, t, nil
+}
+
+// responseBody is the body of a response, read as soon as it is received. It
+// is only kept in memory when an assertion searches it, otherwise it is only
+// measured, and hashed for the integrity assertions, as it is read
+type responseBody struct {
+	*bytes.Reader
+	data []byte
+	size int64
+	// sum is the digest of the body with the strongest expected algorithm
+	sum []byte
+	// truncated indicates that the reading stopped once the body exceeded
+	// --max-size
+	truncated bool
+}
+
+func (b *responseBody) Close() error {
+	return nil
+}
+
+// keepsBody returns whether the assertions search the response body, which
+// must then be kept in memory. The steps of a scenario capture values from it
+func (c *CheckHTTP) keepsBody() bool {
+	return len(c.patterns) > 0 || len(c.missingPatterns) > 0 || c.hasJSONAssertions() || c.scenarioFile != ""
+}
+
+// readBody reads a response body to its end, or until it exceeds --max-body,
+// which is reported as critical, or --max-size, after which the size of the
+// body is known to be too large
+func (c *CheckHTTP) readBody(r io.Reader) (*responseBody, error) {
+	var writers []io.Writer
+
+	var h hash.Hash
+	if c.hasIntegrityAssertions() {
+		// An invalid digest is reported by the integrity assertions
+		if digests, err := c.strongestDigests(); err == nil {
+			h = hashAlgorithms[digests[0].algorithm]()
+			writers = append(writers, h)
+		}
+	}
+
+	var buf bytes.Buffer
+	if c.keepsBody() {
+		writers = append(writers, &buf)
+	}
+
+	limit := c.maxBody
+	if c.maxSize > 0 && (limit == 0 || c.maxSize < limit) {
+		limit = c.maxSize
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && size > limit && limit == c.maxBody {
+		return nil, c.maxBodyError()
+	}
+
+	body := &responseBody{data: buf.Bytes(), size: size, truncated: limit > 0 && size > limit}
+	body.Reader = bytes.NewReader(body.data)
+	if h != nil {
+		body.sum = h.Sum(nil)
+	}
+	return body, nil
+}
+
+// hasBody returns whether a body follows the headers of the response
+func hasBody(req *http.Request, resp *http.Response) bool {
+	switch {
+	case req.Method == http.MethodHead, resp.Body == http.NoBody:
+		return false
+	case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// maxBodyError returns the exit used when the response body exceeds the
+// maximum size that can be read
+func (c *CheckHTTP) maxBodyError() error {
+	return &plugin.Exit{
+		Msg:    fmt.Sprintf("response body exceeds the %s limit of --max-body, reading aborted", formatSize(c.maxBody)),
+		Status: plugin.Critical,
+	}
+}
+
+// requestError converts an error returned while performing the request into
+// an exit
+func (c *CheckHTTP) requestError(err error) error {
+	// Report the redirections that could not be followed with their chain
+	if exit := redirectExit(err); exit != nil {
+		return exit
+	}
+
+	// Distinguish the failures of the proxy from the ones of the origin
+	if isProxyError(err) {
+		return &plugin.Exit{
+			Msg:    "Proxy error: " + err.Error(),
+			Status: plugin.Critical,
 		}
+	}
 
-		// Unknown error
-		return nil, &plugin.Exit{
-			Msg:    "Request error: " + err.Error(),
+	// Report the failures of the TLS handshake precisely
+	if exit := tlsError(err); exit != nil {
+		return exit
+	}
+
+	// If we have an error, verify if it's a timeout
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return &plugin.Exit{
+			Msg:    fmt.Sprintf("Request exceeded timeout of %s", c.timeout),
 			Status: plugin.Critical,
 		}
 	}
 
-	return resp, nil
+	// Unknown error
+	return &plugin.Exit{
+		Msg:    "Request error: " + err.Error(),
+		Status: plugin.Critical,
+	}
 }
 
-func (c *CheckHTTP) prepareClient() *http.Client {
-	t := time.Duration(c.timeout) * time.Second
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-		Timeout: t,
+// newRequest builds the HTTP request described by the configuration
+func (c *CheckHTTP) newRequest() (*http.Request, error) {
+	body, err := c.requestBody()
+	if err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(c.method)
+	if method == "" {
+		method = http.MethodGet
 	}
 
-	return client
+	// Only attach a body when one was provided, so no Content-Length header
+	// is sent with body-less requests
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, c.url, r)
+	if err != nil {
+		return nil, &plugin.Exit{Msg: "Invalid request: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	if c.contentType != "" {
+		req.Header.Set("Content-Type", c.contentType)
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	c.setAuthorization(req)
+
+	// Apply the custom headers last so they replace the default ones
+	headers := http.Header{}
+	for _, header := range c.headers {
+		name, value, err := parseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+
+		// The Host header is not part of the header map and must be set on
+		// the request itself
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+
+		headers.Add(name, value)
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	return req, nil
 }
 
-func (c *CheckHTTP) verifyBody(resp *http.Response) error {
-	responseCode := statusLine(resp.StatusCode)
+// parseHeader splits a header provided in the "Name: value" format
+func parseHeader(header string) (string, string, error) {
+	i := strings.Index(header, ":")
+	if i <= 0 {
+		return "", "", &plugin.Exit{
+			Msg:    fmt.Sprintf("invalid header %q, expected \"Name: value\"", header),
+			Status: plugin.Unknown,
+		}
+	}
+
+	name := strings.TrimSpace(header[:i])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", &plugin.Exit{
+			Msg:    fmt.Sprintf("invalid header name %q", name),
+			Status: plugin.Unknown,
+		}
+	}
+
+	return name, strings.TrimSpace(header[i+1:]), nil
+}
+
+// defaultUserAgent returns the User-Agent sent when none is configured, which
+// identifies the plugin and its version
+func defaultUserAgent() string {
+	return "CheckHTTP/" + version
+}
 
-	// Determine if we have a pattern that must be present or absent
-	pattern := c.pattern
-	if c.missingPattern != "" {
-		pattern = c.missingPattern
+// requestPayload returns a copy of the body of a built request, so it can be
+// signed before being sent
+func requestPayload(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
 	}
+	r, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// writeFileAtomic replaces the content of a file, readable only by the current
+// user. The file is replaced atomically so concurrent checks never read it
+// partially written
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
 
-	if pattern != "" {
-		// Get the response body
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// requestBody returns the body of the request, read either from the inline
+// data, a file or the standard input. A nil slice means that no body is sent
+func (c *CheckHTTP) requestBody() ([]byte, error) {
+	if c.data != "" {
+		return []byte(c.data), nil
+	}
+
+	if c.dataFile == "" {
+		return nil, nil
+	}
+
+	var body []byte
+	var err error
+	if c.dataFile == "-" {
+		body, err = ioutil.ReadAll(stdin)
+	} else {
+		body, err = ioutil.ReadFile(c.dataFile)
+	}
+	if err != nil {
+		return nil, &plugin.Exit{Msg: "Could not read request body: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	return body, nil
+}
+
+func (c *CheckHTTP) prepareClient() (*http.Client, error) {
+	proxy, err := c.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	transport.OnProxyConnectResponse = checkProxyConnect
+
+	// Connect to a single address of the host, or to the addresses of the
+	// overrides, without a proxy in between, which would otherwise connect
+	// to the host instead. The addresses of --all-addresses already take
+	// --resolve into account
+	switch {
+	case c.address != "":
+		dial, err := c.dialAddress(transport.DialContext)
 		if err != nil {
-			return &plugin.Exit{Msg: err.Error(), Status: plugin.Critical}
+			return nil, err
+		}
+		transport.Proxy = nil
+		transport.DialContext = dial
+	case len(c.connectTo) > 0 || len(c.resolve) > 0:
+		dial, err := c.overrideDial(transport.DialContext)
+		if err != nil {
+			return nil, err
 		}
+		transport.Proxy = nil
+		transport.DialContext = dial
+	}
 
-		contentLength := len(body)
+	client := &http.Client{
+		CheckRedirect: c.checkRedirect,
+		Timeout:       c.timeout,
+		Transport:     transport,
+	}
 
-		if strings.Contains(string(body), pattern) {
-			// Determine the status based on whether it must be absent or present
-			status := plugin.OK
-			if c.missingPattern != "" {
-				status = plugin.Critical
-			}
+	// The concurrent checks share the jar of the invocation
+	jar := c.jar
+	if jar == nil {
+		if jar, err = c.newCookieJar(); err != nil {
+			return nil, err
+		}
+	}
+	if jar != nil {
+		client.Jar = jar
+	}
+
+	// Answer the authentication challenges of the server
+	if c.user != "" {
+		client.Transport = &authTransport{
+			transport:  transport,
+			user:       c.user,
+			password:   c.password,
+			digestOnly: c.digest,
+		}
+	}
+
+	return client, nil
+}
+
+// verifyBody runs the assertions on the response body, and reports their
+// failures along with the ones already recorded in the result
+func (c *CheckHTTP) verifyBody(resp *http.Response, r *result) error {
+	responseCode := statusLine(resp.StatusCode)
+
+	if len(c.patterns) == 0 && len(c.missingPatterns) == 0 && !c.hasJSONAssertions() &&
+		!c.hasSizeAssertions() && !c.hasIntegrityAssertions() {
+		if r.status != plugin.OK {
+			responseCode += " " + strings.Join(r.failures, ", ")
+		}
+		return &plugin.Exit{Msg: responseCode, Status: r.status}
+	}
 
-			return &plugin.Exit{
-				Msg:    fmt.Sprintf("%s found /%s/ in %d bytes", responseCode, pattern, contentLength),
-				Status: status,
+	// Get the response body, already read unless the response was built
+	// another way
+	defer resp.Body.Close()
+	body, ok := resp.Body.(*responseBody)
+	if !ok {
+		var err error
+		if body, err = c.readBody(resp.Body); err != nil {
+			if exit, ok := err.(*plugin.Exit); ok {
+				return exit
 			}
+			return &plugin.Exit{Msg: err.Error(), Status: plugin.Critical}
 		}
+	}
+
+	// Only the start of a body larger than --max-size was read, the other
+	// assertions can not apply to it
+	if body.truncated {
+		r.fail(plugin.Critical, "body size is above %s, reading stopped", formatSize(c.maxSize))
+		return &plugin.Exit{Msg: responseCode + " " + strings.Join(r.failures, ", "), Status: r.status}
+	}
+
+	contentLength := body.size
+
+	// Run every assertion so all the failures are reported at once
+	var details []string
+	c.verifySize(contentLength, r)
+	details = append(details, c.verifyPatterns(body.data, r)...)
+	if c.hasJSONAssertions() {
+		details = append(details, c.verifyJSON(body.data, r)...)
+	}
+	if c.hasIntegrityAssertions() {
+		details = append(details, c.verifyIntegrity(body, r)...)
+	}
+
+	status := r.status
+	if status != plugin.OK {
+		details = r.failures
+	}
+
+	msg := responseCode
+	if len(details) > 0 {
+		msg += " " + strings.Join(details, ", ")
+	}
+
+	return &plugin.Exit{
+		Msg:    fmt.Sprintf("%s in %d bytes", msg, contentLength),
+		Status: status,
+	}
+}
+
+// verifyPatterns searches the patterns in the response body, records every
+// failure in the result and returns the details of the successful searches
+func (c *CheckHTTP) verifyPatterns(body []byte, r *result) []string {
+	patterns, err := compilePatterns(c.patterns, c.regex, c.ignoreCase)
+	if err != nil {
+		r.fail(plugin.Unknown, "%s", err)
+		return nil
+	}
+	missingPatterns, err := compilePatterns(c.missingPatterns, c.regex, c.ignoreCase)
+	if err != nil {
+		r.fail(plugin.Unknown, "%s", err)
+		return nil
+	}
 
-		// Determine the status based on whether it must be absent or present
-		status := plugin.Critical
-		if c.missingPattern != "" {
-			status = plugin.OK
+	minCount := c.minCount
+	if minCount < 1 {
+		minCount = 1
+	}
+
+	var found, missing []string
+	for _, pattern := range patterns {
+		count := pattern.count(body)
+		switch {
+		case count == 0:
+			r.fail(plugin.Critical, "did not find %s", pattern)
+		case count < minCount:
+			r.fail(plugin.Critical, "found %s %d times, expected at least %d", pattern, count, minCount)
+		default:
+			found = append(found, pattern.String())
 		}
+	}
+	for _, pattern := range missingPatterns {
+		if count := pattern.count(body); count > 0 {
+			r.fail(plugin.Critical, "found forbidden %s", pattern)
+		} else {
+			missing = append(missing, pattern.String())
+		}
+	}
 
-		return &plugin.Exit{
-			Msg:    fmt.Sprintf("did not found /%s/ in %d bytes", pattern, contentLength),
-			Status: status,
+	var details []string
+	if len(found) > 0 {
+		details = append(details, "found "+strings.Join(found, ", "))
+	}
+	if len(missing) > 0 {
+		details = append(details, "did not find "+strings.Join(missing, ", "))
+	}
+
+	return details
+}
+
+// result accumulates the failures of the assertions of a check, along with
+// the most severe status among them
+type result struct {
+	status   int
+	failures []string
+}
+
+// fail records a failure with the provided status
+func (r *result) fail(status int, format string, args ...interface{}) {
+	if status > r.status {
+		r.status = status
+	}
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// joinExits combines two exits into one with the worst status of both and
+// their messages. A nil exit is ignored
+func joinExits(a, b error) error {
+	exitA, okA := a.(*plugin.Exit)
+	exitB, okB := b.(*plugin.Exit)
+	if !okA || !okB {
+		if a == nil {
+			return b
 		}
+		return a
 	}
 
-	return &plugin.Exit{Msg: responseCode, Status: plugin.OK}
+	exit := &plugin.Exit{
+		Msg:    exitA.Msg + ", " + exitB.Msg,
+		Status: exitA.Status,
+	}
+	if exitB.Status > exit.Status {
+		exit.Status = exitB.Status
+	}
+	return exit
 }
 
 // statusLine returns a string that contains the status code and status text
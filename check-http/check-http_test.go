@@ -5,6 +5,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,14 +32,16 @@ func verifyExitCode(t *testing.T, got error, want int) {
 
 func TestHandleResponse(t *testing.T) {
 	type fields struct {
-		redirectOK   bool
-		responseCode int
+		redirectOK    bool
+		responseCode  int
+		statusMapping string
 	}
 	tests := []struct {
 		name       string
 		fields     fields
 		resp       *http.Response
 		wantStatus int
+		wantMsg    string
 	}{
 		{
 			name:       "200 OK",
@@ -93,6 +98,49 @@ func TestHandleResponse(t *testing.T) {
 			resp:       &http.Response{StatusCode: http.StatusMovedPermanently},
 			wantStatus: plugin.OK,
 		},
+		{
+			name: "401 Unauthorized mapped to OK",
+			fields: fields{
+				statusMapping: "200-204,401=ok",
+			},
+			resp:       &http.Response{StatusCode: http.StatusUnauthorized},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "503 Service Unavailable mapped to warning",
+			fields: fields{
+				statusMapping: "200-204,304=ok;429=warning;503=warning;5xx=critical",
+			},
+			resp:       &http.Response{StatusCode: http.StatusServiceUnavailable},
+			wantStatus: plugin.Warning,
+			wantMsg:    "503 Service Unavailable",
+		},
+		{
+			name: "First matching rule wins",
+			fields: fields{
+				statusMapping: "503=warning;5xx=critical",
+			},
+			resp:       &http.Response{StatusCode: http.StatusBadGateway},
+			wantStatus: plugin.Critical,
+		},
+		{
+			name: "Unmatched code follows the built-in mapping",
+			fields: fields{
+				statusMapping: "401=ok",
+			},
+			resp:       &http.Response{StatusCode: http.StatusFound},
+			wantStatus: plugin.Warning,
+			wantMsg:    "unexpected redirection",
+		},
+		{
+			name: "Mapping overrides --response-code",
+			fields: fields{
+				responseCode:  http.StatusMovedPermanently,
+				statusMapping: "200=warning",
+			},
+			resp:       &http.Response{StatusCode: http.StatusOK},
+			wantStatus: plugin.Warning,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -100,8 +148,18 @@ func TestHandleResponse(t *testing.T) {
 				redirectOK:   tt.fields.redirectOK,
 				responseCode: tt.fields.responseCode,
 			}
+			if tt.fields.statusMapping != "" {
+				if err := c.statusMapping.Set(tt.fields.statusMapping); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.resp.Body = ioutil.NopCloser(strings.NewReader(""))
+
 			exit := c.handleResponse(tt.resp)
 			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
 		})
 	}
 }
@@ -120,7 +178,7 @@ func TestInitiateRequest(t *testing.T) {
 	}
 
 	type fields struct {
-		timeout int
+		timeout time.Duration
 	}
 	tests := []struct {
 		name    string
@@ -136,7 +194,7 @@ func TestInitiateRequest(t *testing.T) {
 		{
 			name: "Timeout",
 			fields: fields{
-				timeout: 1,
+				timeout: time.Second,
 			},
 			handler: timeoutHandler,
 			wantErr: true,
@@ -161,9 +219,12 @@ func TestInitiateRequest(t *testing.T) {
 					//fmt.Println(err)
 				}
 			}()
-			client := c.prepareClient()
+			client, err := c.prepareClient()
+			if err != nil {
+				t.Fatal(err)
+			}
 
-			_, err := c.initiateRequest(client)
+			_, _, err = c.initiateRequest(client)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CheckHTTP.initiateRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -174,19 +235,23 @@ func TestInitiateRequest(t *testing.T) {
 
 func TestVerifyBody(t *testing.T) {
 	type fields struct {
-		missingPattern string
-		pattern        string
+		ignoreCase      bool
+		minCount        int
+		missingPatterns []string
+		patterns        []string
+		regex           bool
 	}
 	tests := []struct {
 		name       string
 		fields     fields
 		resp       *http.Response
 		wantStatus int
+		wantMsg    string
 	}{
 		{
 			name: "Required pattern is present",
 			fields: fields{
-				pattern: "foo",
+				patterns: []string{"foo"},
 			},
 			resp: &http.Response{
 				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
@@ -197,7 +262,7 @@ func TestVerifyBody(t *testing.T) {
 		{
 			name: "Required pattern is missing",
 			fields: fields{
-				pattern: "qux",
+				patterns: []string{"qux"},
 			},
 			resp: &http.Response{
 				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
@@ -208,7 +273,7 @@ func TestVerifyBody(t *testing.T) {
 		{
 			name: "Disallowed pattern is present",
 			fields: fields{
-				missingPattern: "foo",
+				missingPatterns: []string{"foo"},
 			},
 			resp: &http.Response{
 				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
@@ -219,23 +284,487 @@ func TestVerifyBody(t *testing.T) {
 		{
 			name: "Disallowed pattern is missing",
 			fields: fields{
-				missingPattern: "qux",
+				missingPatterns: []string{"qux"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Required and disallowed patterns",
+			fields: fields{
+				missingPatterns: []string{"error"},
+				patterns:        []string{"foo", "bar"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK found /foo/, /bar/, did not find /error/ in 6 bytes",
+		},
+		{
+			name: "Every failing pattern is reported",
+			fields: fields{
+				missingPatterns: []string{"bar"},
+				patterns:        []string{"foo", "qux", "quux"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK did not find /qux/, did not find /quux/, found forbidden /bar/ in 6 bytes",
+		},
+		{
+			name: "Regular expression",
+			fields: fields{
+				patterns: []string{`"status":\s*"UP"`},
+				regex:    true,
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"status": "UP"}`))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Literal pattern is not a regular expression",
+			fields: fields{
+				patterns: []string{"f.o"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.Critical,
+		},
+		{
+			name: "Invalid regular expression",
+			fields: fields{
+				patterns: []string{"(foo"},
+				regex:    true,
 			},
 			resp: &http.Response{
 				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar"))),
 				StatusCode: http.StatusOK,
 			},
+			wantStatus: plugin.Unknown,
+		},
+		{
+			name: "Case-insensitive match",
+			fields: fields{
+				ignoreCase:      true,
+				missingPatterns: []string{"ERROR"},
+				patterns:        []string{"FOO"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("foobar error"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "found forbidden /ERROR/",
+		},
+		{
+			name: "Pattern appears enough times",
+			fields: fields{
+				minCount: 3,
+				patterns: []string{"ok"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("ok ok ok"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Pattern does not appear enough times",
+			fields: fields{
+				minCount: 3,
+				patterns: []string{"ok"},
+			},
+			resp: &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte("ok ok"))),
+				StatusCode: http.StatusOK,
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "found /ok/ 2 times, expected at least 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				ignoreCase:      tt.fields.ignoreCase,
+				minCount:        tt.fields.minCount,
+				missingPatterns: tt.fields.missingPatterns,
+				patterns:        tt.fields.patterns,
+				regex:           tt.fields.regex,
+			}
+			exit := c.verifyBody(tt.resp, &result{})
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestInitiateRequestMethodAndBody(t *testing.T) {
+	file, err := ioutil.TempFile("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`{"from":"file"}`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	type fields struct {
+		contentType string
+		data        string
+		dataFile    string
+		method      string
+	}
+	tests := []struct {
+		name            string
+		fields          fields
+		stdin           string
+		wantMethod      string
+		wantBody        string
+		wantContentType string
+	}{
+		{
+			name:       "Default GET",
+			wantMethod: http.MethodGet,
+		},
+		{
+			name: "POST with inline body",
+			fields: fields{
+				data:   "foo=bar",
+				method: "post",
+			},
+			wantMethod:      http.MethodPost,
+			wantBody:        "foo=bar",
+			wantContentType: "application/x-www-form-urlencoded",
+		},
+		{
+			name: "PUT with body from file",
+			fields: fields{
+				contentType: "application/json",
+				dataFile:    file.Name(),
+				method:      http.MethodPut,
+			},
+			wantMethod:      http.MethodPut,
+			wantBody:        `{"from":"file"}`,
+			wantContentType: "application/json",
+		},
+		{
+			name: "PATCH with body from stdin",
+			fields: fields{
+				contentType: "text/plain",
+				dataFile:    "-",
+				method:      http.MethodPatch,
+			},
+			stdin:           "from stdin",
+			wantMethod:      http.MethodPatch,
+			wantBody:        "from stdin",
+			wantContentType: "text/plain",
+		},
+		{
+			name: "DELETE without body",
+			fields: fields{
+				method: http.MethodDelete,
+			},
+			wantMethod: http.MethodDelete,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotMethod, gotBody, gotContentType string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotMethod = r.Method
+				gotContentType = r.Header.Get("Content-Type")
+				body, _ := ioutil.ReadAll(r.Body)
+				gotBody = string(body)
+			}))
+			defer ts.Close()
+
+			stdin = strings.NewReader(tt.stdin)
+			defer func() { stdin = os.Stdin }()
+
+			c := &CheckHTTP{
+				contentType: tt.fields.contentType,
+				data:        tt.fields.data,
+				dataFile:    tt.fields.dataFile,
+				method:      tt.fields.method,
+				timeout:     time.Second,
+				url:         ts.URL,
+			}
+			client, err := c.prepareClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := c.initiateRequest(client); err != nil {
+				t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+			}
+			if gotMethod != tt.wantMethod {
+				t.Errorf("method = %q, want %q", gotMethod, tt.wantMethod)
+			}
+			if gotBody != tt.wantBody {
+				t.Errorf("body = %q, want %q", gotBody, tt.wantBody)
+			}
+			if gotContentType != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", gotContentType, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestInitiateRequestHeaders(t *testing.T) {
+	type fields struct {
+		headers   []string
+		userAgent string
+	}
+	tests := []struct {
+		name          string
+		fields        fields
+		wantHeaders   http.Header
+		wantHost      string
+		wantUserAgent string
+		wantErr       bool
+	}{
+		{
+			name:          "Default User-Agent",
+			fields:        fields{userAgent: defaultUserAgent()},
+			wantUserAgent: "CheckHTTP/" + version,
+		},
+		{
+			name: "Custom headers",
+			fields: fields{
+				headers: []string{
+					"Authorization: Bearer foo",
+					"X-Tenant: a",
+					"x-tenant: b",
+				},
+				userAgent: "custom/1.0",
+			},
+			wantHeaders: http.Header{
+				"Authorization": {"Bearer foo"},
+				"X-Tenant":      {"a", "b"},
+			},
+			wantUserAgent: "custom/1.0",
+		},
+		{
+			name: "User-Agent header overrides the default one",
+			fields: fields{
+				headers:   []string{"User-Agent: from-header"},
+				userAgent: defaultUserAgent(),
+			},
+			wantUserAgent: "from-header",
+		},
+		{
+			name: "Host override",
+			fields: fields{
+				headers: []string{"Host: vhost.example.com"},
+			},
+			wantHost: "vhost.example.com",
+		},
+		{
+			name: "Invalid header",
+			fields: fields{
+				headers: []string{"no separator"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+			}))
+			defer ts.Close()
+
+			c := &CheckHTTP{
+				headers:   tt.fields.headers,
+				timeout:   time.Second,
+				url:       ts.URL,
+				userAgent: tt.fields.userAgent,
+			}
+			client, err := c.prepareClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.initiateRequest(client)
+			if tt.wantErr {
+				verifyExitCode(t, err, plugin.Unknown)
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+			}
+
+			for name, values := range tt.wantHeaders {
+				if g := got.Header[name]; strings.Join(g, ",") != strings.Join(values, ",") {
+					t.Errorf("header %s = %v, want %v", name, g, values)
+				}
+			}
+			if tt.wantHost != "" && got.Host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", got.Host, tt.wantHost)
+			}
+			if tt.wantUserAgent != "" && got.UserAgent() != tt.wantUserAgent {
+				t.Errorf("User-Agent = %q, want %q", got.UserAgent(), tt.wantUserAgent)
+			}
+		})
+	}
+}
+
+func TestRunResponseTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	type fields struct {
+		criticalTime time.Duration
+		warningTime  time.Duration
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "No thresholds",
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK, response time ",
+		},
+		{
+			name: "Below the thresholds",
+			fields: fields{
+				criticalTime: 2 * time.Second,
+				warningTime:  time.Second,
+			},
 			wantStatus: plugin.OK,
 		},
+		{
+			name: "Warning threshold exceeded",
+			fields: fields{
+				criticalTime: time.Second,
+				warningTime:  50 * time.Millisecond,
+			},
+			wantStatus: plugin.Warning,
+			wantMsg:    "exceeds 50ms",
+		},
+		{
+			name: "Critical threshold exceeded",
+			fields: fields{
+				criticalTime: 50 * time.Millisecond,
+				warningTime:  10 * time.Millisecond,
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "exceeds 50ms",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &CheckHTTP{
-				missingPattern: tt.fields.missingPattern,
-				pattern:        tt.fields.pattern,
+				criticalTime: tt.fields.criticalTime,
+				timeout:      time.Second,
+				url:          ts.URL,
+				warningTime:  tt.fields.warningTime,
 			}
-			exit := c.verifyBody(tt.resp)
+			exit := c.Run()
 			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
 		})
 	}
 }
+
+func TestRunPerfData(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	c := &CheckHTTP{
+		criticalTime: time.Second,
+		timeout:      time.Second,
+		url:          ts.URL,
+		warningTime:  500 * time.Millisecond,
+	}
+	exit := c.Run()
+	verifyExitCode(t, exit, plugin.OK)
+
+	want := regexp.MustCompile(`^OK: 202 Accepted, response time \S+ \(connect \S+, server \S+, transfer \S+\) \| ` +
+		`time=[0-9.]+s;0\.5;1;0 time_dns=0s;;;0 time_connect=[0-9.]+s;;;0 time_tls=0s;;;0 time_server=[0-9.]+s;;;0 time_transfer=[0-9.]+s;;;0 ` +
+		`size=5B;;;0 status_code=202\n$`)
+	if exit == nil || !want.MatchString(exit.Error()) {
+		t.Errorf("exit = %q, want %q", exit, want)
+	}
+}
+
+func TestRunVersion(t *testing.T) {
+	c := newCheckHTTP()
+	if err := c.cmd.ParseFlags([]string{"--version"}); err != nil {
+		t.Fatal(err)
+	}
+	exit := c.Run()
+	verifyExitCode(t, exit, plugin.OK)
+	if got, want := exit.Error(), "OK: version "+version+"\n"; got != want {
+		t.Errorf("exit = %q, want %q", got, want)
+	}
+}
+
+func TestPerfDataString(t *testing.T) {
+	tests := []struct {
+		name     string
+		perfData perfData
+		want     string
+	}{
+		{
+			name:     "Value only",
+			perfData: perfData{label: "status_code", value: 200},
+			want:     "status_code=200",
+		},
+		{
+			name:     "Every field",
+			perfData: perfData{label: "time", value: 0.25, unit: "s", warning: "1", critical: "2", min: "0", max: "15"},
+			want:     "time=0.25s;1;2;0;15",
+		},
+		{
+			name:     "Quoted label",
+			perfData: perfData{label: "it's slow", value: 1.5, unit: "s", min: "0"},
+			want:     "'it''s slow'=1.5s;;;0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.perfData.String(); got != tt.want {
+				t.Errorf("perfData.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := timePerfData("time_dns", 250*time.Microsecond, 0, time.Second).String(), "time_dns=0.00025s;;1;0"; got != want {
+		t.Errorf("timePerfData() = %q, want %q", got, want)
+	}
+
+	if got, want := sanitizeLabel("addr_2001:db8::1"), "addr_2001_db8__1"; got != want {
+		t.Errorf("sanitizeLabel() = %q, want %q", got, want)
+	}
+
+	exit := withPerfData(&plugin.Exit{Msg: "found /a|b/", Status: plugin.OK}, []perfData{{label: "size", value: 3, unit: "B"}})
+	if got, want := exit.Error(), "OK: found /a/b/ | size=3B\n"; got != want {
+		t.Errorf("withPerfData() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// repeatableTypes are the types of the flags that accept several values
+var repeatableTypes = map[string]bool{
+	"stringArray":    true,
+	"stringSlice":    true,
+	"phase=duration": true,
+	"mapping":        true,
+}
+
+// loadConfig applies the configuration file to the flags that were not set on
+// the command line. The keys of the file are the names of the flags, and the
+// values are parsed as the flags would, so the file is validated as strictly
+func (c *CheckHTTP) loadConfig(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &plugin.Exit{Msg: "could not read the configuration: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	// JSON documents are valid YAML documents
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return &plugin.Exit{Msg: fmt.Sprintf("invalid configuration %s: %s", path, err), Status: plugin.Unknown}
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	flags := c.cmd.Flags()
+	for _, key := range keys {
+		flag := flags.Lookup(key)
+		if flag == nil || key == "config" || key == "help" || key == "version" {
+			return &plugin.Exit{Msg: fmt.Sprintf("invalid configuration %s: unknown key %q", path, key), Status: plugin.Unknown}
+		}
+
+		// The flags of the command line override the file
+		if flag.Changed {
+			continue
+		}
+
+		if err := setFlag(flag, config[key]); err != nil {
+			return &plugin.Exit{Msg: fmt.Sprintf("invalid configuration %s: %s: %s", path, key, err), Status: plugin.Unknown}
+		}
+	}
+
+	return nil
+}
+
+// setFlag sets the value of a flag from a decoded value. Lists are only
+// accepted by repeatable flags, and maps are converted to "key=value" items
+// for --phase-warning and --phase-critical. The rules of --status are applied
+// in order, which a map does not keep, so they must be a list
+func setFlag(flag *pflag.Flag, value interface{}) error {
+	var items []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			s, err := scalarValue(item)
+			if err != nil {
+				return err
+			}
+			items = append(items, s)
+		}
+	case map[interface{}]interface{}:
+		if flag.Value.Type() != "phase=duration" {
+			return fmt.Errorf("expected a list of %s values, not a map", flag.Value.Type())
+		}
+		for k, v := range value {
+			s, err := scalarValue(v)
+			if err != nil {
+				return err
+			}
+			items = append(items, fmt.Sprintf("%v=%s", k, s))
+		}
+		sort.Strings(items)
+	default:
+		s, err := scalarValue(value)
+		if err != nil {
+			return err
+		}
+		items = []string{s}
+	}
+
+	if len(items) != 1 && !repeatableTypes[flag.Value.Type()] {
+		return fmt.Errorf("expected a single %s value", flag.Value.Type())
+	}
+
+	for _, item := range items {
+		if err := flag.Value.Set(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalarValue returns the representation of a decoded scalar value
+func scalarValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case []interface{}, map[interface{}]interface{}:
+		return "", fmt.Errorf("unexpected nested value %v", value)
+	case nil:
+		return "", nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// validateCommand returns the subcommand that validates a configuration file
+// without running the check
+func (c *CheckHTTP) validateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate FILE",
+		Short: "Validate a configuration file without running the check",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.validateConfig(args[0])
+		},
+	}
+}
+
+// validateConfig loads the configuration file and verifies everything that
+// can be verified without performing the request
+func (c *CheckHTTP) validateConfig(path string) error {
+	if err := c.loadConfig(path); err != nil {
+		return err
+	}
+	if err := c.loadURLs(); err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	invalid := func(err error) error {
+		return &plugin.Exit{Msg: fmt.Sprintf("invalid configuration %s: %s", path, err), Status: plugin.Unknown}
+	}
+
+	if c.scenarioFile != "" {
+		if _, err := loadScenario(c.scenarioFile); err != nil {
+			return err
+		}
+	}
+
+	for _, patterns := range [][]string{c.patterns, c.missingPatterns} {
+		if _, err := compilePatterns(patterns, c.regex, c.ignoreCase); err != nil {
+			return invalid(err)
+		}
+	}
+
+	for _, expr := range c.jsonPaths {
+		if _, err := parseJSONPath(expr); err != nil {
+			return invalid(err)
+		}
+	}
+	for _, expr := range append(c.jsonWarning[:len(c.jsonWarning):len(c.jsonWarning)], c.jsonCritical...) {
+		if _, err := parseJSONThreshold(expr, plugin.Warning); err != nil {
+			return invalid(err)
+		}
+	}
+
+	for _, expr := range append(c.headerWarning[:len(c.headerWarning):len(c.headerWarning)], c.headerCritical...) {
+		if _, err := parseHeaderAssertion(expr, plugin.Warning); err != nil {
+			return invalid(err)
+		}
+	}
+
+	if c.checksum != "" || c.integrity != "" {
+		if _, err := c.expectedDigests(); err != nil {
+			return invalid(err)
+		}
+	}
+
+	if _, err := c.tlsConfig(); err != nil {
+		return err
+	}
+	if _, err := c.proxyFunc(); err != nil {
+		return err
+	}
+	if _, err := c.overrideDial(nil); err != nil {
+		return err
+	}
+
+	return &plugin.Exit{Msg: fmt.Sprintf("configuration %s is valid", path), Status: plugin.OK}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunConfig(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Api-Key") != "k3y" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"method": %q, "status": "UP"}`, r.Method)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		file       string
+		config     string
+		args       []string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name: "YAML",
+			file: "check.yml",
+			config: `
+url: ` + ts.URL + `
+method: post
+timeout: 5s
+header:
+  - "X-Api-Key: k3y"
+response-header: Content-Type == application/json
+jsonpath: [$.method, $.status]
+expect: [POST, UP]
+phase-warning:
+  server: 1s
+  transfer: 500ms
+status:
+  - 2xx=ok
+  - 5xx=critical
+`,
+			wantStatus: plugin.OK,
+			wantMsg:    `$.method is "POST", $.status is "UP"`,
+		},
+		{
+			name:       "JSON",
+			file:       "check.json",
+			config:     `{"url": "` + ts.URL + `", "header": ["X-Api-Key: k3y"], "query": ["UP"], "regex": true}`,
+			wantStatus: plugin.OK,
+			wantMsg:    "found /UP/",
+		},
+		{
+			name: "Flags override the file",
+			file: "override.yml",
+			config: `
+url: ` + ts.URL + `
+method: POST
+header: ["X-Api-Key: wrong"]
+jsonpath: [$.method]
+expect: [POST]
+`,
+			args:       []string{"--method", "PUT", "-H", "X-Api-Key: k3y", "--expect", "PUT"},
+			wantStatus: plugin.OK,
+			wantMsg:    `$.method is "PUT"`,
+		},
+		{
+			name:       "Unknown key",
+			file:       "unknown.yml",
+			config:     "url: " + ts.URL + "\nquerry: UP\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    `unknown key "querry"`,
+		},
+		{
+			name:       "Invalid value",
+			file:       "invalid.yml",
+			config:     "url: " + ts.URL + "\ntimeout: soon\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    `timeout: invalid duration "soon"`,
+		},
+		{
+			name:       "List of a single value flag",
+			file:       "list.yml",
+			config:     "url: " + ts.URL + "\nmethod: [GET, POST]\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "method: expected a single string value",
+		},
+		{
+			name:       "Map of status rules",
+			file:       "status.yml",
+			config:     "url: " + ts.URL + "\nstatus:\n  2xx: ok\n  5xx: critical\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "status: expected a list of mapping values, not a map",
+		},
+		{
+			name:       "Invalid document",
+			file:       "document.yml",
+			config:     "- " + ts.URL + "\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "invalid configuration",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c := newCheckHTTP()
+			if err := c.cmd.ParseFlags(append([]string{"--config", file}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		config     string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Valid",
+			config:     "url: https://example.com\nquery: [healthy]\njson-critical: [$.latency > 200]\n",
+			wantStatus: plugin.OK,
+			wantMsg:    "is valid",
+		},
+		{
+			name:       "Missing URL",
+			config:     "query: [healthy]\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "no URL specified",
+		},
+		{
+			name:       "Invalid regular expression",
+			config:     "url: https://example.com\nregex: true\nquery: [\"(unclosed\"]\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "missing closing )",
+		},
+		{
+			name:       "Invalid header assertion",
+			config:     "url: https://example.com\nresponse-header: [Server > 1]\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    "invalid header assertion",
+		},
+		{
+			name:       "Unknown key",
+			config:     "url: https://example.com\nwarning_time: 1s\n",
+			wantStatus: plugin.Unknown,
+			wantMsg:    `unknown key "warning_time"`,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, fmt.Sprintf("check-%d.yml", i))
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c := newCheckHTTP()
+			c.cmd.SetArgs([]string{"validate", file})
+			exit := c.cmd.Execute()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// persistentJar is a cookie jar that remembers the cookies it receives, so
+// they can be saved to a file and restored by the next run
+type persistentJar struct {
+	*cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]storedCookie
+}
+
+// storedCookie is a cookie, as saved to a file, with the URL that set it
+type storedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+func newPersistentJar() (*persistentJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &persistentJar{Jar: jar, cookies: map[string]storedCookie{}}, nil
+}
+
+func (j *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cookie := range cookies {
+		// A cookie replaces the previous one with the same name, domain and
+		// path, and a cookie that already expired deletes it
+		key := strings.Join([]string{u.Hostname(), cookie.Domain, cookiePath(u, cookie), cookie.Name}, "|")
+		if expired(cookie) {
+			delete(j.cookies, key)
+			continue
+		}
+
+		// Max-Age is relative to the time the cookie is received, it is saved
+		// as an absolute expiration so the next runs do not extend it
+		if cookie.MaxAge > 0 {
+			copied := *cookie
+			copied.Expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+			copied.MaxAge = 0
+			cookie = &copied
+		}
+		j.cookies[key] = storedCookie{URL: u.String(), Cookie: cookie}
+	}
+}
+
+// cookiePath returns the path of the cookie, which defaults to the directory
+// of the URL that set it when the Path attribute is missing, as described by
+// RFC 6265
+func cookiePath(u *url.URL, cookie *http.Cookie) string {
+	if strings.HasPrefix(cookie.Path, "/") {
+		return cookie.Path
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return u.Path[:i]
+}
+
+// expired returns whether the cookie must be discarded
+func expired(cookie *http.Cookie) bool {
+	return cookie.MaxAge < 0 || !cookie.Expires.IsZero() && cookie.Expires.Before(time.Now())
+}
+
+// load restores the cookies saved to the file, if it exists
+func (j *persistentJar) load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var cookies []storedCookie
+	if err := json.Unmarshal(b, &cookies); err != nil {
+		return fmt.Errorf("invalid cookie jar %s: %s", path, err)
+	}
+
+	for _, stored := range cookies {
+		u, err := url.Parse(stored.URL)
+		if err != nil || stored.Cookie == nil {
+			continue
+		}
+		j.SetCookies(u, []*http.Cookie{stored.Cookie})
+	}
+	return nil
+}
+
+// save writes the cookies that have not expired to the file, session cookies
+// included, readable only by the current user
+func (j *persistentJar) save(path string) error {
+	j.mu.Lock()
+	cookies := make([]storedCookie, 0, len(j.cookies))
+	for _, stored := range j.cookies {
+		if !expired(stored.Cookie) {
+			cookies = append(cookies, stored)
+		}
+	}
+	j.mu.Unlock()
+
+	b, err := json.MarshalIndent(cookies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// newCookieJar returns the cookie jar of the check, restored from the file of
+// --cookie-jar, or nil when cookies are not used
+func (c *CheckHTTP) newCookieJar() (*persistentJar, error) {
+	if !c.cookies && c.cookieJar == "" && c.loginURL == "" && c.scenarioFile == "" {
+		return nil, nil
+	}
+
+	jar, err := newPersistentJar()
+	if err != nil {
+		return nil, &plugin.Exit{Msg: "could not create the cookie jar: " + err.Error(), Status: plugin.Unknown}
+	}
+	if c.cookieJar != "" {
+		if err := jar.load(c.cookieJar); err != nil {
+			return nil, &plugin.Exit{Msg: "could not load the cookie jar: " + err.Error(), Status: plugin.Unknown}
+		}
+	}
+	return jar, nil
+}
+
+// login posts the credentials to the login URL, so the session cookies it
+// returns are stored in the jar of the client
+func (c *CheckHTTP) login(client *http.Client) error {
+	req, err := http.NewRequest(http.MethodPost, c.loginURL, strings.NewReader(c.loginData))
+	if err != nil {
+		return &plugin.Exit{Msg: "Invalid login request: " + err.Error(), Status: plugin.Unknown}
+	}
+	req.Header.Set("Content-Type", c.loginContentType)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &plugin.Exit{Msg: "login failed: " + err.Error(), Status: plugin.Critical}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	// Login forms usually redirect once the session is created
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &plugin.Exit{Msg: "login failed: " + statusLine(resp.StatusCode), Status: plugin.Critical}
+	}
+	return nil
+}
+
+// hasSession returns whether the jar of the client already holds the session
+// cookie for the checked URL, restored from a previous run. Without
+// --session-cookie, any cookie is considered a session
+func (c *CheckHTTP) hasSession(client *http.Client) bool {
+	u, err := url.Parse(c.url)
+	if err != nil || client.Jar == nil {
+		return false
+	}
+
+	for _, cookie := range client.Jar.Cookies(u) {
+		if c.sessionCookie == "" || cookie.Name == c.sessionCookie {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionExpired returns whether the response asks to log in again: the
+// access is denied, or the request was redirected to the login URL
+func (c *CheckHTTP) sessionExpired(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return true
+	}
+
+	login, err := url.Parse(c.loginURL)
+	if err != nil {
+		return false
+	}
+	isLogin := func(u *url.URL) bool {
+		return u.Host == login.Host && u.Path == login.Path
+	}
+
+	if location, err := resp.Location(); err == nil && isLogin(location) {
+		return true
+	}
+	chain := redirectChain(resp)
+	for i := 1; i < len(chain); i++ {
+		if isLogin(chain[i].URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveCookies saves the cookie jar of the client to the configured file. A
+// failure only means the session will be created again by the next run. The
+// jar shared by concurrent checks is saved once they are all done
+func (c *CheckHTTP) saveCookies(client *http.Client) {
+	if c.jar != nil {
+		return
+	}
+	if jar, ok := client.Jar.(*persistentJar); ok && c.cookieJar != "" {
+		jar.save(c.cookieJar)
+	}
+}
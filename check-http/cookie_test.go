@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	logins := 0
+	sessions := map[string]bool{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.PostFormValue("username") != "sensu" || r.PostFormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		logins++
+		session := fmt.Sprintf("session-%d", logins)
+		sessions[session] = true
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: session, Path: "/", HttpOnly: true})
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		cookie, err := r.Cookie("session")
+		if err != nil || !sessions[cookie.Value] {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		w.Write([]byte("Welcome back"))
+	})
+	mux.HandleFunc("/set", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "visited", Value: "yes"})
+		http.Redirect(w, r, "/get", http.StatusFound)
+	})
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("visited"); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	jarFile := filepath.Join(dir, "cookies.json")
+	newCheck := func(loginData, cookieJar string) *CheckHTTP {
+		return &CheckHTTP{
+			cookieJar:        cookieJar,
+			loginContentType: "application/x-www-form-urlencoded",
+			loginData:        loginData,
+			loginURL:         ts.URL + "/login",
+			patterns:         []string{"Welcome"},
+			timeout:          time.Second,
+			url:              ts.URL + "/dashboard",
+		}
+	}
+	run := func(c *CheckHTTP, wantStatus int, wantLogins int, wantMsg string) {
+		t.Helper()
+		exit := c.Run()
+		verifyExitCode(t, exit, wantStatus)
+		if exit != nil && !strings.Contains(exit.Error(), wantMsg) {
+			t.Errorf("exit = %q, want %q", exit.Error(), wantMsg)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if logins != wantLogins {
+			t.Errorf("logged in %d times, want %d", logins, wantLogins)
+		}
+	}
+
+	// Without a cookie jar file, every run logs in
+	run(newCheck("username=sensu&password=secret", ""), plugin.OK, 1, "found /Welcome/")
+	run(newCheck("username=sensu&password=secret", ""), plugin.OK, 2, "found /Welcome/")
+	run(newCheck("username=sensu&password=wrong", ""), plugin.Critical, 2, "login failed: 401 Unauthorized")
+
+	// The session saved to the cookie jar is reused by the next run
+	run(newCheck("username=sensu&password=secret", jarFile), plugin.OK, 3, "found /Welcome/")
+	run(newCheck("username=sensu&password=secret", jarFile), plugin.OK, 3, "found /Welcome/")
+	info, err := os.Stat(jarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cookie jar mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// An expired session is created again
+	mu.Lock()
+	sessions = map[string]bool{}
+	mu.Unlock()
+	run(newCheck("username=sensu&password=secret", jarFile), plugin.OK, 4, "found /Welcome/")
+	run(newCheck("username=sensu&password=secret", jarFile), plugin.OK, 4, "found /Welcome/")
+
+	// Cookies set during redirections are sent back
+	c := &CheckHTTP{
+		cookies:         true,
+		followRedirects: true,
+		maxRedirects:    10,
+		timeout:         time.Second,
+		url:             ts.URL + "/set",
+	}
+	verifyExitCode(t, c.Run(), plugin.OK)
+}
+
+func TestPersistentJarMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jar, err := newPersistentJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://example.com/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "s3ss10n", Path: "/", MaxAge: 3600}})
+
+	file := filepath.Join(dir, "cookies.json")
+	if err := jar.save(file); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []storedCookie
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("saved %d cookies, want 1", len(stored))
+	}
+	cookie := stored[0].Cookie
+	if cookie.MaxAge != 0 {
+		t.Errorf("MaxAge = %d, want 0", cookie.MaxAge)
+	}
+	if expires := time.Until(cookie.Expires); expires <= 59*time.Minute || expires > time.Hour {
+		t.Errorf("Expires = %v, want in an hour", cookie.Expires)
+	}
+
+	// A restored cookie keeps its expiration and is dropped once it passed
+	stored[0].Cookie.Expires = time.Now().Add(-time.Minute)
+	if b, err = json.Marshal(stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := newPersistentJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.load(file); err != nil {
+		t.Fatal(err)
+	}
+	if cookies := restored.Cookies(u); len(cookies) != 0 {
+		t.Errorf("restored %v, want no cookie", cookies)
+	}
+}
+
+func TestPersistentJarPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jar, err := newPersistentJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cookies of the same name, without Path attribute, set by different
+	// directories of the site
+	for _, app := range []string{"a", "b"} {
+		u, _ := url.Parse("http://example.com/" + app + "/login")
+		jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: app}})
+	}
+
+	file := filepath.Join(dir, "cookies.json")
+	if err := jar.save(file); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := newPersistentJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, app := range []string{"a", "b"} {
+		u, _ := url.Parse("http://example.com/" + app + "/home")
+		cookies := restored.Cookies(u)
+		if len(cookies) != 1 || cookies[0].Value != app {
+			t.Errorf("restored %v for %s, want session=%s", cookies, u, app)
+		}
+	}
+}
+
+func TestHasSession(t *testing.T) {
+	jar, err := newPersistentJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://example.com/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "theme", Value: "dark", Path: "/"}})
+	client := &http.Client{Jar: jar}
+
+	tests := []struct {
+		name          string
+		sessionCookie string
+		want          bool
+	}{
+		{name: "Any cookie", want: true},
+		{name: "Session cookie missing", sessionCookie: "session", want: false},
+		{name: "Session cookie present", sessionCookie: "theme", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{sessionCookie: tt.sessionCookie, url: u.String()}
+			if got := c.hasSession(client); got != tt.want {
+				t.Errorf("hasSession() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// durationValue is a flag value that accepts Go durations, such as "750ms" or
+// "1m30s", as well as plain integers that are interpreted as seconds
+type durationValue time.Duration
+
+func newDurationValue(p *time.Duration, value time.Duration) *durationValue {
+	*p = value
+	return (*durationValue)(p)
+}
+
+func (d *durationValue) Set(s string) error {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		*d = durationValue(time.Duration(seconds) * time.Second)
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a Go duration (e.g. 750ms) or a number of seconds", s)
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationValue) Type() string {
+	return "duration"
+}
+
+// formatDuration returns a human-readable duration, rounded to the millisecond
+// or, below one millisecond, to the microsecond
+func formatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "15", want: 15 * time.Second},
+		{value: "0", want: 0},
+		{value: "750ms", want: 750 * time.Millisecond},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "1.5s", want: 1500 * time.Millisecond},
+		{value: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var got time.Duration
+			v := newDurationValue(&got, time.Minute)
+
+			err := v.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("durationValue.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("durationValue.Set() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// headerOperators are the operators of header assertions that compare the
+// value of the header, with a string or a regular expression
+var headerOperators = []string{"==", "=~", "!~"}
+
+// headerAssertion is an assertion, such as "Content-Type == application/json",
+// on a response header, that changes the status of the check when it is not
+// satisfied
+type headerAssertion struct {
+	name   string
+	op     string
+	value  string
+	re     *regexp.Regexp
+	status int
+}
+
+// parseHeaderAssertion parses a "NAME exists", "NAME absent" or
+// "NAME OPERATOR VALUE" assertion, where the operator is == (equals), =~
+// (matches the regular expression) or !~ (does not match it, which an absent
+// header satisfies). A bare name is an exists assertion
+func parseHeaderAssertion(expr string, status int) (headerAssertion, error) {
+	expr = strings.TrimSpace(expr)
+	i := strings.IndexAny(expr, " \t=!")
+	if i < 0 {
+		i = len(expr)
+	}
+
+	a := headerAssertion{name: http.CanonicalHeaderKey(expr[:i]), status: status}
+	if a.name == "" {
+		return a, fmt.Errorf("invalid header assertion %q, missing header name", expr)
+	}
+
+	rest := strings.TrimSpace(expr[i:])
+	switch strings.ToLower(rest) {
+	case "", "exists":
+		a.op = "exists"
+		return a, nil
+	case "absent":
+		a.op = "absent"
+		return a, nil
+	}
+
+	for _, op := range headerOperators {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+
+		a.op = op
+		a.value = strings.TrimSpace(rest[len(op):])
+		if op == "==" {
+			return a, nil
+		}
+
+		re, err := regexp.Compile(a.value)
+		if err != nil {
+			return a, fmt.Errorf("invalid header assertion %q: %s", expr, err)
+		}
+		a.re = re
+		return a, nil
+	}
+
+	return a, fmt.Errorf("invalid header assertion %q, expected \"NAME exists\", \"NAME absent\" or \"NAME OPERATOR VALUE\"", expr)
+}
+
+// verify evaluates the assertion against the response headers and records
+// its failure in the result
+func (a headerAssertion) verify(header http.Header, r *result) {
+	values, present := header[a.name]
+
+	switch a.op {
+	case "exists":
+		if !present {
+			r.fail(a.status, "header %s is missing", a.name)
+		}
+		return
+	case "absent":
+		if present {
+			r.fail(a.status, "header %s is present (%s)", a.name, formatHeaderValues(values))
+		}
+		return
+	}
+
+	// An absent header does not match anything, so it satisfies !~, e.g.
+	// "Server !~ [0-9]". Add "NAME exists" to also require the header
+	if !present {
+		if a.op != "!~" {
+			r.fail(a.status, "header %s is missing", a.name)
+		}
+		return
+	}
+
+	// A header repeated several times satisfies the assertion when any of its
+	// values does, except for !~ that none of them must match
+	switch a.op {
+	case "==":
+		for _, value := range values {
+			if value == a.value {
+				return
+			}
+		}
+		r.fail(a.status, "header %s is %s, expected %q", a.name, formatHeaderValues(values), a.value)
+	case "=~":
+		for _, value := range values {
+			if a.re.MatchString(value) {
+				return
+			}
+		}
+		r.fail(a.status, "header %s is %s, does not match /%s/", a.name, formatHeaderValues(values), a.value)
+	case "!~":
+		for _, value := range values {
+			if a.re.MatchString(value) {
+				r.fail(a.status, "header %s is %s, matches /%s/", a.name, formatHeaderValues(values), a.value)
+				return
+			}
+		}
+	}
+}
+
+// formatHeaderValues returns the quoted values of a header
+func formatHeaderValues(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// verifyHeaders evaluates the header assertions against the response headers
+// and records every failure in the result
+func (c *CheckHTTP) verifyHeaders(header http.Header, r *result) {
+	for _, assertion := range []struct {
+		exprs  []string
+		status int
+	}{
+		{c.headerCritical, plugin.Critical},
+		{c.headerWarning, plugin.Warning},
+	} {
+		for _, expr := range assertion.exprs {
+			a, err := parseHeaderAssertion(expr, assertion.status)
+			if err != nil {
+				r.fail(plugin.Unknown, "%s", err)
+				continue
+			}
+			a.verify(header, r)
+		}
+	}
+}
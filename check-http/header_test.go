@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestParseHeaderAssertion(t *testing.T) {
+	tests := []struct {
+		expr      string
+		wantName  string
+		wantOp    string
+		wantValue string
+		wantErr   bool
+	}{
+		{expr: "strict-transport-security", wantName: "Strict-Transport-Security", wantOp: "exists"},
+		{expr: "ETag exists", wantName: "Etag", wantOp: "exists"},
+		{expr: "X-Powered-By absent", wantName: "X-Powered-By", wantOp: "absent"},
+		{expr: "Content-Type == application/json", wantName: "Content-Type", wantOp: "==", wantValue: "application/json"},
+		{expr: "Content-Type==text/html; charset=utf-8", wantName: "Content-Type", wantOp: "==", wantValue: "text/html; charset=utf-8"},
+		{expr: "Cache-Control =~ max-age=\\d+", wantName: "Cache-Control", wantOp: "=~", wantValue: "max-age=\\d+"},
+		{expr: "Server !~ [0-9]", wantName: "Server", wantOp: "!~", wantValue: "[0-9]"},
+		{expr: "Server !~ [0-9", wantErr: true},
+		{expr: "Server > 1", wantErr: true},
+		{expr: "== value", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			a, err := parseHeaderAssertion(tt.expr, plugin.Critical)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHeaderAssertion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if a.name != tt.wantName || a.op != tt.wantOp || a.value != tt.wantValue {
+				t.Errorf("parseHeaderAssertion() = %q %q %q, want %q %q %q",
+					a.name, a.op, a.value, tt.wantName, tt.wantOp, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestHandleResponseHeaders(t *testing.T) {
+	header := http.Header{
+		"Content-Type":              {"text/html"},
+		"Server":                    {"nginx/1.18.0"},
+		"Strict-Transport-Security": {"max-age=31536000"},
+		"Vary":                      {"Accept", "Accept-Encoding"},
+	}
+
+	type fields struct {
+		headerCritical []string
+		headerWarning  []string
+		patterns       []string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name: "Satisfied assertions",
+			fields: fields{
+				headerCritical: []string{"Strict-Transport-Security", "X-Powered-By absent", "Content-Type =~ ^text/", "Vary == Accept-Encoding"},
+				headerWarning:  []string{"Server !~ apache"},
+			},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name: "Missing header",
+			fields: fields{
+				headerCritical: []string{"Content-Security-Policy exists"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK header Content-Security-Policy is missing",
+		},
+		{
+			name: "Unexpected value",
+			fields: fields{
+				headerCritical: []string{"Content-Type == application/json"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    `header Content-Type is "text/html", expected "application/json"`,
+		},
+		{
+			name: "Version leaked",
+			fields: fields{
+				headerWarning: []string{"Server !~ [0-9]"},
+			},
+			wantStatus: plugin.Warning,
+			wantMsg:    `header Server is "nginx/1.18.0", matches /[0-9]/`,
+		},
+		{
+			name: "Absent header does not match",
+			fields: fields{
+				headerWarning: []string{"X-Powered-By !~ [0-9]"},
+			},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name: "Absent header required by exists",
+			fields: fields{
+				headerWarning: []string{"X-Powered-By exists", "X-Powered-By !~ [0-9]"},
+			},
+			wantStatus: plugin.Warning,
+			wantMsg:    "200 OK header X-Powered-By is missing",
+		},
+		{
+			name: "Absent header compared",
+			fields: fields{
+				headerCritical: []string{"X-Powered-By =~ ^Express$"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK header X-Powered-By is missing",
+		},
+		{
+			name: "Header and body failures reported together",
+			fields: fields{
+				headerCritical: []string{"Server absent"},
+				headerWarning:  []string{"Content-Type =~ json"},
+				patterns:       []string{"healthy"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg: `200 OK header Server is present ("nginx/1.18.0"), ` +
+				`header Content-Type is "text/html", does not match /json/, did not find /healthy/ in 13 bytes`,
+		},
+		{
+			name: "Invalid assertion",
+			fields: fields{
+				headerCritical: []string{"Server >= 1"},
+			},
+			wantStatus: plugin.Unknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				headerCritical: tt.fields.headerCritical,
+				headerWarning:  tt.fields.headerWarning,
+				patterns:       tt.fields.patterns,
+			}
+			resp := &http.Response{
+				Body:       ioutil.NopCloser(strings.NewReader("<html></html>")),
+				Header:     header,
+				StatusCode: http.StatusOK,
+			}
+
+			exit := c.handleResponse(resp)
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// hmacAlgorithms are the supported HMAC hash algorithms, by name
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// hmacPlaceholder matches the placeholders of the canonical string template
+var hmacPlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// canonicalHMACString builds the string to sign from the template, where
+// {method}, {path}, {query}, {timestamp} and {body} are replaced by the
+// values of the request, and \n by a line break
+func canonicalHMACString(template string, req *http.Request, timestamp string, body []byte) (string, error) {
+	values := map[string]string{
+		"method":    req.Method,
+		"path":      req.URL.EscapedPath(),
+		"query":     req.URL.RawQuery,
+		"timestamp": timestamp,
+		"body":      string(body),
+	}
+
+	// Only the line breaks of the template are unescaped, the values are
+	// signed as they are sent
+	template = strings.Replace(template, `\n`, "\n", -1)
+
+	var err error
+	s := hmacPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder[1:len(placeholder)-1]]
+		if !ok && err == nil {
+			err = fmt.Errorf("unknown placeholder %s in --hmac-template, expected {method}, {path}, {query}, {timestamp} or {body}", placeholder)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s, nil
+}
+
+// signHMAC adds the HMAC signature of the request to the configured header,
+// along with the timestamp used in the signature when a header is configured
+// for it
+func (c *CheckHTTP) signHMAC(req *http.Request) error {
+	algorithm := strings.ToLower(c.hmacAlgorithm)
+	newHash, ok := hmacAlgorithms[algorithm]
+	if !ok {
+		return &plugin.Exit{
+			Msg:    fmt.Sprintf("unsupported HMAC algorithm %q, expected sha1, sha256, sha384 or sha512", c.hmacAlgorithm),
+			Status: plugin.Unknown,
+		}
+	}
+
+	body, err := requestPayload(req)
+	if err != nil {
+		return &plugin.Exit{Msg: "could not sign the request: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	timestamp := strconv.FormatInt(signingTime().Unix(), 10)
+	s, err := canonicalHMACString(c.hmacTemplate, req, timestamp, body)
+	if err != nil {
+		return &plugin.Exit{Msg: err.Error(), Status: plugin.Unknown}
+	}
+
+	mac := hmac.New(newHash, []byte(c.hmacSecret))
+	mac.Write([]byte(s))
+	sum := mac.Sum(nil)
+
+	var signature string
+	switch strings.ToLower(c.hmacEncoding) {
+	case "hex":
+		signature = hex.EncodeToString(sum)
+	case "base64":
+		signature = base64.StdEncoding.EncodeToString(sum)
+	default:
+		return &plugin.Exit{
+			Msg:    fmt.Sprintf("unsupported HMAC encoding %q, expected hex or base64", c.hmacEncoding),
+			Status: plugin.Unknown,
+		}
+	}
+
+	if c.hmacTimestampHeader != "" {
+		req.Header.Set(c.hmacTimestampHeader, timestamp)
+	}
+	req.Header.Set(c.hmacHeader, c.hmacPrefix+signature)
+	return nil
+}
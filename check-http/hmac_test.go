@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunHMAC(t *testing.T) {
+	// Slack example of https://api.slack.com/authentication/verifying-requests-from-slack
+	const slackBody = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V" +
+		"&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=" +
+		"&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+		"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+
+	signingTime = func() time.Time { return time.Unix(1531420618, 0) }
+	defer func() { signingTime = time.Now }()
+
+	mac := hmac.New(sha512.New, []byte("s3cret"))
+	mac.Write([]byte("POST\n/hooks/order\nid=42\n1531420618\n{\"order\":42}"))
+	templateSignature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	// The escaped line break of the JSON body is signed as it is sent
+	mac = hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1531420618\n" + `{"note":"a\nb"}`))
+	escapedSignature := hex.EncodeToString(mac.Sum(nil))
+
+	type fields struct {
+		data                string
+		hmacAlgorithm       string
+		hmacEncoding        string
+		hmacHeader          string
+		hmacPrefix          string
+		hmacSecret          string
+		hmacTemplate        string
+		hmacTimestampHeader string
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		path        string
+		wantHeaders map[string]string
+		wantStatus  int
+	}{
+		{
+			name: "GitHub",
+			fields: fields{
+				data:         "Hello, World!",
+				hmacHeader:   "X-Hub-Signature-256",
+				hmacPrefix:   "sha256=",
+				hmacSecret:   "It's a Secret to Everybody",
+				hmacTemplate: "{body}",
+			},
+			path: "/",
+			wantHeaders: map[string]string{
+				"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Slack",
+			fields: fields{
+				data:                slackBody,
+				hmacHeader:          "X-Slack-Signature",
+				hmacPrefix:          "v0=",
+				hmacSecret:          "8f742231b10e8888abcd99yyyzzz85a5",
+				hmacTemplate:        "v0:{timestamp}:{body}",
+				hmacTimestampHeader: "X-Slack-Request-Timestamp",
+			},
+			path: "/",
+			wantHeaders: map[string]string{
+				"X-Slack-Signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+				"X-Slack-Request-Timestamp": "1531420618",
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Template with every placeholder",
+			fields: fields{
+				data:          `{"order":42}`,
+				hmacAlgorithm: "SHA512",
+				hmacEncoding:  "base64",
+				hmacSecret:    "s3cret",
+				hmacTemplate:  `{method}\n{path}\n{query}\n{timestamp}\n{body}`,
+			},
+			path: "/hooks/order?id=42",
+			wantHeaders: map[string]string{
+				"X-Signature": templateSignature,
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Escaped line break in the body",
+			fields: fields{
+				data:         `{"note":"a\nb"}`,
+				hmacSecret:   "s3cret",
+				hmacTemplate: `{timestamp}\n{body}`,
+			},
+			path: "/",
+			wantHeaders: map[string]string{
+				"X-Signature": escapedSignature,
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Unknown placeholder",
+			fields: fields{
+				hmacSecret:   "s3cret",
+				hmacTemplate: "{host}{body}",
+			},
+			path:       "/",
+			wantStatus: plugin.Unknown,
+		},
+		{
+			name: "Unsupported algorithm",
+			fields: fields{
+				hmacAlgorithm: "md5",
+				hmacSecret:    "s3cret",
+			},
+			path:       "/",
+			wantStatus: plugin.Unknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, want := range tt.wantHeaders {
+					if got := r.Header.Get(name); got != want {
+						t.Errorf("%s = %q, want %q", name, got, want)
+						w.WriteHeader(http.StatusUnauthorized)
+					}
+				}
+				ioutil.ReadAll(r.Body)
+			}))
+			defer ts.Close()
+
+			c := &CheckHTTP{
+				data:                tt.fields.data,
+				hmacAlgorithm:       "sha256",
+				hmacEncoding:        "hex",
+				hmacHeader:          "X-Signature",
+				hmacSecret:          tt.fields.hmacSecret,
+				hmacTemplate:        "{body}",
+				hmacPrefix:          tt.fields.hmacPrefix,
+				hmacTimestampHeader: tt.fields.hmacTimestampHeader,
+				method:              http.MethodPost,
+				timeout:             time.Second,
+				url:                 ts.URL + tt.path,
+			}
+			for _, override := range []struct {
+				field *string
+				value string
+			}{
+				{&c.hmacAlgorithm, tt.fields.hmacAlgorithm},
+				{&c.hmacEncoding, tt.fields.hmacEncoding},
+				{&c.hmacHeader, tt.fields.hmacHeader},
+				{&c.hmacTemplate, tt.fields.hmacTemplate},
+			} {
+				if override.value != "" {
+					*override.field = override.value
+				}
+			}
+
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if tt.wantStatus == plugin.Unknown && exit != nil && !strings.Contains(exit.Error(), "hmac") &&
+				!strings.Contains(exit.Error(), "HMAC") {
+				t.Errorf("exit = %q, want an HMAC error", exit.Error())
+			}
+		})
+	}
+}
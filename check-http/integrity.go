@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// hashAlgorithms are the supported digest algorithms, by name
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// digest is an expected digest of the response body
+type digest struct {
+	algorithm string
+	sum       []byte
+	// sri indicates that the digest is represented in the Subresource
+	// Integrity format, base64 encoded, instead of hexadecimal
+	sri bool
+}
+
+// format returns the representation of a sum, in the same format as the
+// expected digest
+func (d digest) format(sum []byte) string {
+	if d.sri {
+		return d.algorithm + "-" + base64.StdEncoding.EncodeToString(sum)
+	}
+	return d.algorithm + ":" + hex.EncodeToString(sum)
+}
+
+// parseChecksum parses a hexadecimal checksum, optionally prefixed by its
+// algorithm (e.g. "sha256:9f86d0..."). Without a prefix, the algorithm is
+// deduced from the length of the checksum
+func parseChecksum(s string) (digest, error) {
+	s = strings.TrimSpace(s)
+
+	algorithm := ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		algorithm, s = strings.ToLower(s[:i]), s[i+1:]
+	}
+
+	sum, err := hex.DecodeString(s)
+	if err != nil {
+		return digest{}, fmt.Errorf("invalid checksum %q, expected a hexadecimal value", s)
+	}
+
+	if algorithm == "" {
+		switch len(sum) {
+		case sha256.Size:
+			algorithm = "sha256"
+		case sha512.Size384:
+			algorithm = "sha384"
+		case sha512.Size:
+			algorithm = "sha512"
+		default:
+			return digest{}, fmt.Errorf("invalid checksum %q, unknown length", s)
+		}
+	}
+
+	return newDigest(algorithm, sum, false)
+}
+
+// parseIntegrity parses a Subresource Integrity value, such as
+// "sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC",
+// which can contain several space-separated digests
+func parseIntegrity(s string) ([]digest, error) {
+	var digests []digest
+	for _, token := range strings.Fields(s) {
+		// Ignore the options that may follow the digest
+		if i := strings.Index(token, "?"); i >= 0 {
+			token = token[:i]
+		}
+
+		i := strings.Index(token, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid integrity %q, expected \"algorithm-base64digest\"", token)
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(token[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid integrity %q, the digest is not base64 encoded", token)
+		}
+
+		d, err := newDigest(strings.ToLower(token[:i]), sum, true)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, d)
+	}
+
+	if len(digests) == 0 {
+		return nil, fmt.Errorf("invalid integrity %q, no digest found", s)
+	}
+
+	return digests, nil
+}
+
+// newDigest validates the algorithm and the length of the sum
+func newDigest(algorithm string, sum []byte, sri bool) (digest, error) {
+	h, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return digest{}, fmt.Errorf("unsupported digest algorithm %q, expected sha256, sha384 or sha512", algorithm)
+	}
+	if len(sum) != h().Size() {
+		return digest{}, fmt.Errorf("invalid %s digest, expected %d bytes, got %d", algorithm, h().Size(), len(sum))
+	}
+
+	return digest{algorithm: algorithm, sum: sum, sri: sri}, nil
+}
+
+// expectedDigests returns the digests the response body must match
+func (c *CheckHTTP) expectedDigests() ([]digest, error) {
+	if c.integrity != "" {
+		return parseIntegrity(c.integrity)
+	}
+
+	d, err := parseChecksum(c.checksum)
+	if err != nil {
+		return nil, err
+	}
+	return []digest{d}, nil
+}
+
+// hasIntegrityAssertions returns whether the digest of the body must be
+// verified
+func (c *CheckHTTP) hasIntegrityAssertions() bool {
+	return c.checksum != "" || c.integrity != ""
+}
+
+// strongestDigests returns the expected digests using the strongest
+// algorithm. As for Subresource Integrity, only these digests are considered
+// and the body must match one of them
+func (c *CheckHTTP) strongestDigests() ([]digest, error) {
+	digests, err := c.expectedDigests()
+	if err != nil {
+		return nil, err
+	}
+
+	strongest := digests[0].algorithm
+	for _, d := range digests[1:] {
+		if hashAlgorithms[d.algorithm]().Size() > hashAlgorithms[strongest]().Size() {
+			strongest = d.algorithm
+		}
+	}
+
+	var kept []digest
+	for _, d := range digests {
+		if d.algorithm == strongest {
+			kept = append(kept, d)
+		}
+	}
+	return kept, nil
+}
+
+// verifyIntegrity compares the digest of the response body, computed while it
+// was read, against the expected digests, and records the failure in the
+// result
+func (c *CheckHTTP) verifyIntegrity(body *responseBody, r *result) []string {
+	digests, err := c.strongestDigests()
+	if err != nil {
+		r.fail(plugin.Unknown, "%s", err)
+		return nil
+	}
+
+	algorithm := digests[0].algorithm
+	var expected []string
+	for _, d := range digests {
+		if bytes.Equal(d.sum, body.sum) {
+			return []string{algorithm + " digest matches"}
+		}
+		expected = append(expected, d.format(d.sum))
+	}
+
+	r.fail(plugin.Critical, "%s digest mismatch, expected %s, got %s",
+		algorithm, strings.Join(expected, " or "), digests[0].format(body.sum))
+	return nil
+}
+
+// fetchChecksum downloads the expected checksum of the body, in the format of
+// the sha256sum tool, from the checksum URL. A URL starting with a dot, such
+// as ".sha256", is a suffix appended to the path of the checked URL, before its
+// query string. The checksum is requested like the checked URL, with its
+// headers, authentication and signature, and its redirections are followed
+func (c *CheckHTTP) fetchChecksum(client *http.Client) error {
+	checksumURL := c.checksumURL
+	if strings.HasPrefix(checksumURL, ".") {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			return &plugin.Exit{Msg: "Invalid request: " + err.Error(), Status: plugin.Unknown}
+		}
+		u.Path += checksumURL
+		if u.RawPath != "" {
+			u.RawPath += checksumURL
+		}
+		checksumURL = u.String()
+	}
+
+	cc := *c
+	cc.url = checksumURL
+	cc.method = http.MethodGet
+	cc.data, cc.dataFile, cc.contentType = "", "", ""
+	cc.followRedirects = true
+	checksumClient := *client
+	checksumClient.CheckRedirect = cc.checkRedirect
+
+	resp, _, err := cc.sendRequest(&checksumClient)
+	if err != nil {
+		if exit, ok := err.(*plugin.Exit); ok {
+			exit.Msg = fmt.Sprintf("could not fetch the checksum from %s: %s", checksumURL, exit.Msg)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &plugin.Exit{
+			Msg:    fmt.Sprintf("could not fetch the checksum from %s: %s", checksumURL, statusLine(resp.StatusCode)),
+			Status: plugin.Critical,
+		}
+	}
+
+	// A checksum file is small, never read more than a few lines
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return &plugin.Exit{
+			Msg:    fmt.Sprintf("could not fetch the checksum from %s: %s", checksumURL, err),
+			Status: plugin.Critical,
+		}
+	}
+
+	// The checksum is the first field of the file, which may be followed by
+	// the name of the file
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return &plugin.Exit{
+			Msg:    fmt.Sprintf("no checksum found at %s", checksumURL),
+			Status: plugin.Critical,
+		}
+	}
+
+	checksum := fields[0]
+	for algorithm := range hashAlgorithms {
+		if strings.HasSuffix(checksumURL, "."+algorithm) && !strings.Contains(checksum, ":") {
+			checksum = algorithm + ":" + checksum
+		}
+	}
+
+	c.checksum = checksum
+	return nil
+}
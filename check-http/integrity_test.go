@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestVerifyBodyIntegrity(t *testing.T) {
+	body := []byte("console.log('hello');\n")
+	sum256 := sha256.Sum256(body)
+	sum384 := sha512.Sum384(body)
+	sum512 := sha512.Sum512(body)
+	other := sha512.Sum384([]byte("tampered"))
+
+	type fields struct {
+		checksum  string
+		integrity string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "SHA-256 checksum",
+			fields:     fields{checksum: hex.EncodeToString(sum256[:])},
+			wantStatus: plugin.OK,
+			wantMsg:    "sha256 digest matches",
+		},
+		{
+			name:       "SHA-512 checksum with prefix",
+			fields:     fields{checksum: "SHA512:" + hex.EncodeToString(sum512[:])},
+			wantStatus: plugin.OK,
+			wantMsg:    "sha512 digest matches",
+		},
+		{
+			name:       "Checksum mismatch",
+			fields:     fields{checksum: hex.EncodeToString(other[:])},
+			wantStatus: plugin.Critical,
+			wantMsg: "sha384 digest mismatch, expected sha384:" + hex.EncodeToString(other[:]) +
+				", got sha384:" + hex.EncodeToString(sum384[:]),
+		},
+		{
+			name:       "SRI",
+			fields:     fields{integrity: "sha384-" + base64.StdEncoding.EncodeToString(sum384[:])},
+			wantStatus: plugin.OK,
+			wantMsg:    "sha384 digest matches",
+		},
+		{
+			name:       "SRI mismatch",
+			fields:     fields{integrity: "sha384-" + base64.StdEncoding.EncodeToString(other[:])},
+			wantStatus: plugin.Critical,
+			wantMsg: "expected sha384-" + base64.StdEncoding.EncodeToString(other[:]) +
+				", got sha384-" + base64.StdEncoding.EncodeToString(sum384[:]),
+		},
+		{
+			name: "SRI with several digests uses the strongest algorithm",
+			fields: fields{integrity: "sha256-" + base64.StdEncoding.EncodeToString(other[:sha256.Size]) +
+				" sha384-" + base64.StdEncoding.EncodeToString(other[:]) +
+				" sha384-" + base64.StdEncoding.EncodeToString(sum384[:])},
+			wantStatus: plugin.OK,
+			wantMsg:    "sha384 digest matches",
+		},
+		{
+			name:       "Unsupported algorithm",
+			fields:     fields{integrity: "md5-" + base64.StdEncoding.EncodeToString(sum256[:16])},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "unsupported digest algorithm",
+		},
+		{
+			name:       "Invalid checksum",
+			fields:     fields{checksum: "not-hexadecimal"},
+			wantStatus: plugin.Unknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				checksum:  tt.fields.checksum,
+				integrity: tt.fields.integrity,
+			}
+			resp := &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader(body)),
+				StatusCode: http.StatusOK,
+			}
+
+			exit := c.verifyBody(resp, &result{})
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRunChecksumURL(t *testing.T) {
+	artifact := []byte("release artifact")
+	sum := sha256.Sum256(artifact)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/release.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(artifact)
+	})
+	mux.HandleFunc("/release.tar.gz.sha256", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(hex.EncodeToString(sum[:]) + "  release.tar.gz\n"))
+	})
+	mux.HandleFunc("/protected/release.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(artifact)
+	})
+	mux.HandleFunc("/protected/release.tar.gz.sha256", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" || r.Header.Get("X-Tenant") != "sensu" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/checksums/release.tar.gz.sha256", http.StatusFound)
+	})
+	mux.HandleFunc("/checksums/release.tar.gz.sha256", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(hex.EncodeToString(sum[:]) + "  release.tar.gz\n"))
+	})
+	mux.HandleFunc("/tampered.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered artifact"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		url         string
+		checksumURL string
+		bearerToken string
+		headers     []string
+		wantStatus  int
+		wantMsg     string
+	}{
+		{
+			name:        "Sibling checksum",
+			url:         ts.URL + "/release.tar.gz",
+			checksumURL: ".sha256",
+			wantStatus:  plugin.OK,
+			wantMsg:     "sha256 digest matches",
+		},
+		{
+			name:        "Sibling checksum of a URL with a query string",
+			url:         ts.URL + "/release.tar.gz?version=1.0",
+			checksumURL: ".sha256",
+			wantStatus:  plugin.OK,
+			wantMsg:     "sha256 digest matches",
+		},
+		{
+			name:        "Protected and redirected checksum",
+			url:         ts.URL + "/protected/release.tar.gz",
+			checksumURL: ".sha256",
+			bearerToken: "t0ken",
+			headers:     []string{"X-Tenant: sensu"},
+			wantStatus:  plugin.OK,
+			wantMsg:     "sha256 digest matches",
+		},
+		{
+			name:        "Checksum mismatch",
+			url:         ts.URL + "/tampered.tar.gz",
+			checksumURL: ts.URL + "/release.tar.gz.sha256",
+			wantStatus:  plugin.Critical,
+			wantMsg:     "sha256 digest mismatch, expected sha256:" + hex.EncodeToString(sum[:]),
+		},
+		{
+			name:        "Missing checksum",
+			url:         ts.URL + "/tampered.tar.gz",
+			checksumURL: ".sha256",
+			wantStatus:  plugin.Critical,
+			wantMsg:     "could not fetch the checksum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				bearerToken:  tt.bearerToken,
+				checksumURL:  tt.checksumURL,
+				headers:      tt.headers,
+				maxRedirects: 10,
+				timeout:      time.Second,
+				url:          tt.url,
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
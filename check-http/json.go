@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// jsonPath is a parsed path expression, made of object keys (string) and
+// array indexes (int)
+type jsonPath struct {
+	expr  string
+	steps []interface{}
+}
+
+// parseJSONPath parses a JSONPath-style expression such as $.db.latency_ms,
+// $.items[0].name or $['key.with.dots']
+func parseJSONPath(expr string) (jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	path := jsonPath{expr: expr}
+
+	s := expr
+	if !strings.HasPrefix(s, "$") {
+		return path, fmt.Errorf("invalid JSON path %q, must start with $", expr)
+	}
+	s = s[1:]
+
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return path, fmt.Errorf("invalid JSON path %q, empty key", expr)
+			}
+			path.steps = append(path.steps, s[:end])
+			s = s[end:]
+		case '[':
+			end := bracketEnd(s)
+			if end < 0 {
+				return path, fmt.Errorf("invalid JSON path %q, missing ]", expr)
+			}
+			selector := s[1:end]
+			s = s[end+1:]
+
+			if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				path.steps = append(path.steps, selector[1:len(selector)-1])
+				continue
+			}
+
+			index, err := strconv.Atoi(selector)
+			if err != nil {
+				return path, fmt.Errorf("invalid JSON path %q, unsupported selector [%s]", expr, selector)
+			}
+			path.steps = append(path.steps, index)
+		default:
+			return path, fmt.Errorf("invalid JSON path %q, unexpected %q", expr, s[0])
+		}
+	}
+
+	return path, nil
+}
+
+// lookup returns the value found at the path in the decoded document, and
+// whether it exists. Negative indexes are counted from the end of arrays
+func (p jsonPath) lookup(doc interface{}) (interface{}, bool) {
+	value := doc
+	for _, step := range p.steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			if step < 0 {
+				step += len(array)
+			}
+			if step < 0 || step >= len(array) {
+				return nil, false
+			}
+			value = array[step]
+		}
+	}
+
+	return value, true
+}
+
+func (p jsonPath) String() string {
+	return p.expr
+}
+
+// jsonOperators are the supported comparison operators, longest first so
+// that ">=" is not mistaken for ">"
+var jsonOperators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// jsonThreshold is a condition, such as "$.db.latency_ms > 200", that changes
+// the status of the check when it is met
+type jsonThreshold struct {
+	path   jsonPath
+	op     string
+	value  string
+	status int
+}
+
+// parseJSONThreshold parses a "PATH OPERATOR VALUE" condition. The operator
+// is only looked for after the path, whose keys may contain operators, e.g.
+// $['a>b']
+func parseJSONThreshold(expr string, status int) (jsonThreshold, error) {
+	end := jsonPathEnd(expr)
+	rest := strings.TrimSpace(expr[end:])
+	for _, op := range jsonOperators {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+
+		path, err := parseJSONPath(expr[:end])
+		if err != nil {
+			return jsonThreshold{}, err
+		}
+
+		return jsonThreshold{
+			path:   path,
+			op:     op,
+			value:  strings.TrimSpace(rest[len(op):]),
+			status: status,
+		}, nil
+	}
+
+	return jsonThreshold{}, fmt.Errorf("invalid JSON condition %q, expected \"PATH OPERATOR VALUE\"", expr)
+}
+
+// jsonPathEnd returns the index of the end of the path at the start of expr.
+// Dotted keys end at a space or an operator, and bracketed selectors at their
+// closing bracket, outside of quotes
+func jsonPathEnd(expr string) int {
+	i := len(expr) - len(strings.TrimLeft(expr, " \t"))
+	if i < len(expr) && expr[i] == '$' {
+		i++
+	}
+
+	for i < len(expr) {
+		switch expr[i] {
+		case '.':
+			i++
+			for i < len(expr) && !strings.ContainsRune(".[ \t=!<>", rune(expr[i])) {
+				i++
+			}
+		case '[':
+			end := bracketEnd(expr[i:])
+			if end < 0 {
+				return len(expr)
+			}
+			i += end + 1
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// bracketEnd returns the index of the bracket closing the selector at the
+// start of s, skipping the brackets within quotes, or -1 if it is not closed
+func bracketEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == 0 && s[i] == ']':
+			return i
+		case quote == 0 && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case s[i] == quote:
+			quote = 0
+		}
+	}
+	return -1
+}
+
+// decodeJSON decodes a document, which must be the whole body. Numbers are
+// kept as json.Number so they are reported as written
+func decodeJSON(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the document")
+	}
+	return doc, nil
+}
+
+// compareJSON evaluates "actual OPERATOR expected". Values are compared as
+// numbers when both are numeric, and as strings otherwise, in which case only
+// the equality operators are supported
+func compareJSON(actual interface{}, op, expected string) (bool, error) {
+	a := formatJSONValue(actual, false)
+
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(expected, 64)
+	if _, isString := actual.(string); !isString && errA == nil && errB == nil {
+		switch op {
+		case "==":
+			return x == y, nil
+		case "!=":
+			return x != y, nil
+		case ">":
+			return x > y, nil
+		case ">=":
+			return x >= y, nil
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		}
+	}
+
+	expected = strings.Trim(expected, `"'`)
+	switch op {
+	case "==":
+		return a == expected, nil
+	case "!=":
+		return a != expected, nil
+	}
+
+	return false, fmt.Errorf("can not compare non-numeric value %s with %s", formatJSONValue(actual, true), op)
+}
+
+// formatJSONValue returns the representation of a decoded JSON value. Strings
+// are quoted only if requested
+func formatJSONValue(value interface{}, quote bool) string {
+	switch value := value.(type) {
+	case string:
+		if quote {
+			return strconv.Quote(value)
+		}
+		return value
+	case json.Number:
+		return value.String()
+	case nil:
+		return "null"
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+// hasJSONAssertions returns whether the body must be verified as JSON
+func (c *CheckHTTP) hasJSONAssertions() bool {
+	return len(c.jsonPaths) > 0 || len(c.jsonWarning) > 0 || len(c.jsonCritical) > 0
+}
+
+// verifyJSON evaluates the JSON assertions against the response body and
+// records every failure in the result
+func (c *CheckHTTP) verifyJSON(body []byte, r *result) []string {
+	paths := make([]jsonPath, 0, len(c.jsonPaths))
+	for _, expr := range c.jsonPaths {
+		path, err := parseJSONPath(expr)
+		if err != nil {
+			r.fail(plugin.Unknown, "%s", err)
+			return nil
+		}
+		paths = append(paths, path)
+	}
+
+	var thresholds []jsonThreshold
+	for _, condition := range []struct {
+		exprs  []string
+		status int
+	}{
+		{c.jsonCritical, plugin.Critical},
+		{c.jsonWarning, plugin.Warning},
+	} {
+		for _, expr := range condition.exprs {
+			threshold, err := parseJSONThreshold(expr, condition.status)
+			if err != nil {
+				r.fail(plugin.Unknown, "%s", err)
+				return nil
+			}
+			thresholds = append(thresholds, threshold)
+		}
+	}
+
+	doc, err := decodeJSON(body)
+	if err != nil {
+		r.fail(plugin.Critical, "invalid JSON response: %s", err)
+		return nil
+	}
+
+	var details []string
+	for i, path := range paths {
+		value, ok := path.lookup(doc)
+		if !ok {
+			r.fail(plugin.Critical, "%s not found", path)
+			continue
+		}
+
+		actual := formatJSONValue(value, true)
+		if i >= len(c.jsonExpect) {
+			details = append(details, fmt.Sprintf("%s is %s", path, actual))
+			continue
+		}
+
+		if formatJSONValue(value, false) != c.jsonExpect[i] {
+			r.fail(plugin.Critical, "%s is %s, expected %q", path, actual, c.jsonExpect[i])
+			continue
+		}
+		details = append(details, fmt.Sprintf("%s is %s", path, actual))
+	}
+
+	// A path matched by a critical condition is not evaluated again against
+	// the warning ones
+	failed := map[string]bool{}
+	for _, threshold := range thresholds {
+		if failed[threshold.path.expr] {
+			continue
+		}
+
+		value, ok := threshold.path.lookup(doc)
+		if !ok {
+			r.fail(plugin.Critical, "%s not found", threshold.path)
+			failed[threshold.path.expr] = true
+			continue
+		}
+
+		met, err := compareJSON(value, threshold.op, threshold.value)
+		if err != nil {
+			r.fail(plugin.Unknown, "%s: %s", threshold.path, err)
+			failed[threshold.path.expr] = true
+			continue
+		}
+		if met {
+			r.fail(threshold.status, "%s is %s (%s %s)", threshold.path, formatJSONValue(value, true), threshold.op, threshold.value)
+			failed[threshold.path.expr] = true
+		}
+	}
+
+	return details
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    []interface{}
+		wantErr bool
+	}{
+		{expr: "$", want: nil},
+		{expr: "$.status", want: []interface{}{"status"}},
+		{expr: "$.db.latency_ms", want: []interface{}{"db", "latency_ms"}},
+		{expr: "$.items[0].name", want: []interface{}{"items", 0, "name"}},
+		{expr: "$['key.with.dots'][-1]", want: []interface{}{"key.with.dots", -1}},
+		{expr: `$["quoted"]`, want: []interface{}{"quoted"}},
+		{expr: "$['a]b'].c", want: []interface{}{"a]b", "c"}},
+		{expr: `$["it's]"][1]`, want: []interface{}{"it's]", 1}},
+		{expr: "status", wantErr: true},
+		{expr: "$.items[0", wantErr: true},
+		{expr: "$.items[*]", wantErr: true},
+		{expr: "$..status", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseJSONPath(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJSONPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.steps, tt.want) {
+				t.Errorf("parseJSONPath() = %v, want %v", got.steps, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONThreshold(t *testing.T) {
+	tests := []struct {
+		expr      string
+		wantPath  []interface{}
+		wantOp    string
+		wantValue string
+		wantErr   bool
+	}{
+		{expr: "$.db.latency_ms > 200", wantPath: []interface{}{"db", "latency_ms"}, wantOp: ">", wantValue: "200"},
+		{expr: "$.status!=UP", wantPath: []interface{}{"status"}, wantOp: "!=", wantValue: "UP"},
+		{expr: "$.count >= 1", wantPath: []interface{}{"count"}, wantOp: ">=", wantValue: "1"},
+		{expr: "$['a>b'] == 1", wantPath: []interface{}{"a>b"}, wantOp: "==", wantValue: "1"},
+		{expr: `$["x==y"][0] < 5`, wantPath: []interface{}{"x==y", 0}, wantOp: "<", wantValue: "5"},
+		{expr: "$['a]b'] == 1", wantPath: []interface{}{"a]b"}, wantOp: "==", wantValue: "1"},
+		{expr: "$.status == a<b", wantPath: []interface{}{"status"}, wantOp: "==", wantValue: "a<b"},
+		{expr: "$.status", wantErr: true},
+		{expr: "status > 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseJSONThreshold(tt.expr, plugin.Critical)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJSONThreshold() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.path.steps, tt.wantPath) || got.op != tt.wantOp || got.value != tt.wantValue {
+				t.Errorf("parseJSONThreshold() = %v %q %q, want %v %q %q",
+					got.path.steps, got.op, got.value, tt.wantPath, tt.wantOp, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestVerifyBodyJSON(t *testing.T) {
+	const body = `{"status":"UP","db":{"latency_ms":12,"up":true},"nodes":[{"name":"a"},{"name":"b"}]}`
+
+	type fields struct {
+		jsonCritical []string
+		jsonExpect   []string
+		jsonPaths    []string
+		jsonWarning  []string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		body       string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name: "Expected values",
+			fields: fields{
+				jsonExpect: []string{"UP", "12", "true", "b"},
+				jsonPaths:  []string{"$.status", "$.db.latency_ms", "$.db.up", "$.nodes[-1].name"},
+			},
+			wantStatus: plugin.OK,
+			wantMsg:    `$.status is "UP", $.db.latency_ms is 12`,
+		},
+		{
+			name: "Path exists",
+			fields: fields{
+				jsonPaths: []string{"$.db"},
+			},
+			wantStatus: plugin.OK,
+			wantMsg:    `$.db is {"latency_ms":12,"up":true}`,
+		},
+		{
+			name: "Unexpected value",
+			fields: fields{
+				jsonExpect: []string{"DOWN"},
+				jsonPaths:  []string{"$.status"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    `$.status is "UP", expected "DOWN"`,
+		},
+		{
+			name: "Missing path",
+			fields: fields{
+				jsonPaths: []string{"$.cache.status"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "$.cache.status not found",
+		},
+		{
+			name: "Thresholds not met",
+			fields: fields{
+				jsonCritical: []string{"$.db.latency_ms > 200"},
+				jsonWarning:  []string{"$.db.latency_ms > 100", "$.status != UP"},
+			},
+			wantStatus: plugin.OK,
+		},
+		{
+			name: "Warning threshold",
+			fields: fields{
+				jsonCritical: []string{"$.db.latency_ms > 200"},
+				jsonWarning:  []string{"$.db.latency_ms >= 10"},
+			},
+			wantStatus: plugin.Warning,
+			wantMsg:    "$.db.latency_ms is 12 (>= 10)",
+		},
+		{
+			name: "Critical threshold takes precedence",
+			fields: fields{
+				jsonCritical: []string{"$.db.latency_ms > 10"},
+				jsonWarning:  []string{"$.db.latency_ms > 5"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK $.db.latency_ms is 12 (> 10) in",
+		},
+		{
+			name: "String condition",
+			fields: fields{
+				jsonCritical: []string{`$.status == "UP"`},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    `$.status is "UP" (== "UP")`,
+		},
+		{
+			name: "Every failure is reported",
+			fields: fields{
+				jsonCritical: []string{"$.db.latency_ms > 10"},
+				jsonExpect:   []string{"DOWN"},
+				jsonPaths:    []string{"$.status"},
+			},
+			wantStatus: plugin.Critical,
+			wantMsg:    `$.status is "UP", expected "DOWN", $.db.latency_ms is 12 (> 10)`,
+		},
+		{
+			name: "Numeric operator on a string",
+			fields: fields{
+				jsonWarning: []string{"$.status > 1"},
+			},
+			wantStatus: plugin.Unknown,
+		},
+		{
+			name: "Invalid condition",
+			fields: fields{
+				jsonWarning: []string{"$.status"},
+			},
+			wantStatus: plugin.Unknown,
+		},
+		{
+			name: "Invalid JSON",
+			fields: fields{
+				jsonPaths: []string{"$.status"},
+			},
+			body:       "<html>Service Unavailable</html>",
+			wantStatus: plugin.Critical,
+			wantMsg:    "invalid JSON response",
+		},
+		{
+			name: "Trailing data",
+			fields: fields{
+				jsonPaths: []string{"$.status"},
+			},
+			body:       `{"status":"UP"} {"status":"DOWN"}`,
+			wantStatus: plugin.Critical,
+			wantMsg:    "invalid JSON response: unexpected data after the document",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				jsonCritical: tt.fields.jsonCritical,
+				jsonExpect:   tt.fields.jsonExpect,
+				jsonPaths:    tt.fields.jsonPaths,
+				jsonWarning:  tt.fields.jsonWarning,
+			}
+
+			b := tt.body
+			if b == "" {
+				b = body
+			}
+			resp := &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte(b))),
+				StatusCode: http.StatusOK,
+			}
+
+			exit := c.verifyBody(resp, &result{})
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// oauth2ExpiryMargin is how long before its expiration a cached token is
+// renewed, so it can not expire during the check
+const oauth2ExpiryMargin = 30 * time.Second
+
+// oauth2Token is an access token, as cached on disk
+type oauth2Token struct {
+	AccessToken string    `json:"access_token"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// oauth2Error returns the exit used when the access token can not be
+// obtained, which says nothing about the health of the checked URL
+func oauth2Error(format string, args ...interface{}) error {
+	return &plugin.Exit{
+		Msg:    "OAuth2 token endpoint error: " + fmt.Sprintf(format, args...),
+		Status: plugin.Unknown,
+	}
+}
+
+// oauth2AccessToken returns an access token obtained with the client
+// credentials grant, from the cache when it has not expired yet
+func (c *CheckHTTP) oauth2AccessToken(client *http.Client) (string, error) {
+	cache := c.oauth2CachePath()
+	if token, ok := readOAuth2Token(cache); ok {
+		return token.AccessToken, nil
+	}
+
+	token, err := c.requestOAuth2Token(client)
+	if err != nil {
+		return "", err
+	}
+
+	// A failure to cache the token only means it will be requested again
+	writeOAuth2Token(cache, token)
+	return token.AccessToken, nil
+}
+
+// requestOAuth2Token requests a new access token from the token endpoint
+func (c *CheckHTTP) requestOAuth2Token(client *http.Client) (oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.oauth2Scopes) > 0 {
+		form.Set("scope", strings.Join(c.oauth2Scopes, " "))
+	}
+	if c.oauth2Audience != "" {
+		form.Set("audience", c.oauth2Audience)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.oauth2TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, oauth2Error("%s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	// The client credentials are form-encoded before being sent with Basic
+	// authentication, as required by RFC 6749
+	req.SetBasicAuth(url.QueryEscape(c.oauth2ClientID), url.QueryEscape(c.oauth2ClientSecret))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return oauth2Token{}, oauth2Error("%s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return oauth2Token{}, oauth2Error("%s", err)
+	}
+
+	var payload struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	decodeErr := json.Unmarshal(body, &payload)
+
+	if resp.StatusCode != http.StatusOK {
+		msg := statusLine(resp.StatusCode)
+		if payload.Error != "" {
+			msg += ": " + payload.Error
+		}
+		if payload.ErrorDescription != "" {
+			msg += " (" + payload.ErrorDescription + ")"
+		}
+		return oauth2Token{}, oauth2Error("%s", msg)
+	}
+	if decodeErr != nil {
+		return oauth2Token{}, oauth2Error("invalid response: %s", decodeErr)
+	}
+	if payload.AccessToken == "" {
+		return oauth2Token{}, oauth2Error("no access token in the response")
+	}
+	if payload.TokenType != "" && !strings.EqualFold(payload.TokenType, "bearer") {
+		return oauth2Token{}, oauth2Error("unsupported token type %q", payload.TokenType)
+	}
+
+	// A token without expiration is never cached
+	token := oauth2Token{AccessToken: payload.AccessToken}
+	if seconds, err := payload.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.Expiry = start.Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
+
+// oauth2CachePath returns the file where the access token is cached, or an
+// empty string if the cache is disabled. The name of the file depends on every
+// setting of the grant, so a change of settings requests a new token
+func (c *CheckHTTP) oauth2CachePath() string {
+	if c.oauth2CacheDir == "" {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, setting := range []string{c.oauth2TokenURL, c.oauth2ClientID, c.oauth2ClientSecret, strings.Join(c.oauth2Scopes, " "), c.oauth2Audience} {
+		io.WriteString(h, setting+"\x00")
+	}
+	return filepath.Join(c.oauth2CacheDir, "check-http-oauth2-"+hex.EncodeToString(h.Sum(nil))[:16]+".json")
+}
+
+// readOAuth2Token returns the token cached in the file, and whether it exists
+// and is still valid
+func readOAuth2Token(path string) (oauth2Token, bool) {
+	if path == "" {
+		return oauth2Token{}, false
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return oauth2Token{}, false
+	}
+
+	var token oauth2Token
+	if err := json.Unmarshal(b, &token); err != nil || token.AccessToken == "" {
+		return oauth2Token{}, false
+	}
+	if !time.Now().Add(oauth2ExpiryMargin).Before(token.Expiry) {
+		return oauth2Token{}, false
+	}
+	return token, true
+}
+
+// writeOAuth2Token caches the token in the file
+func writeOAuth2Token(path string, token oauth2Token) error {
+	if path == "" || token.Expiry.IsZero() {
+		return nil
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunOAuth2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+
+		id, secret, _ := r.BasicAuth()
+		if id != "check" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"Client authentication failed"}`)
+			return
+		}
+		if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read health" ||
+			r.PostFormValue("audience") != "https://api.example.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, tokenRequests)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	newCheck := func(clientSecret string) *CheckHTTP {
+		return &CheckHTTP{
+			oauth2Audience:     "https://api.example.com",
+			oauth2CacheDir:     dir,
+			oauth2ClientID:     "check",
+			oauth2ClientSecret: clientSecret,
+			oauth2Scopes:       []string{"read", "health"},
+			oauth2TokenURL:     ts.URL + "/token",
+			patterns:           []string{"Bearer token-1"},
+			timeout:            time.Second,
+			url:                ts.URL + "/api",
+		}
+	}
+
+	// The token is requested once, then read from the cache
+	for i := 0; i < 2; i++ {
+		exit := newCheck("s3cret").Run()
+		verifyExitCode(t, exit, plugin.OK)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("token requested %d times, want 1", tokenRequests)
+	}
+
+	cache := newCheck("s3cret").oauth2CachePath()
+	info, err := os.Stat(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cache mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// An expired token is renewed
+	if err := writeOAuth2Token(cache, oauth2Token{AccessToken: "token-1", Expiry: time.Now().Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	c := newCheck("s3cret")
+	c.patterns = []string{"Bearer token-2"}
+	verifyExitCode(t, c.Run(), plugin.OK)
+
+	// The failures of the token endpoint are not the ones of the target
+	exit := newCheck("wrong").Run()
+	verifyExitCode(t, exit, plugin.Unknown)
+	want := "OAuth2 token endpoint error: 401 Unauthorized: invalid_client (Client authentication failed)"
+	if exit == nil || !strings.Contains(exit.Error(), want) {
+		t.Errorf("exit = %v, want %q", exit, want)
+	}
+
+	c = newCheck("s3cret")
+	c.oauth2TokenURL = "http://127.0.0.1:1/token"
+	exit = c.Run()
+	verifyExitCode(t, exit, plugin.Unknown)
+	if exit == nil || !strings.Contains(exit.Error(), "OAuth2 token endpoint error") {
+		t.Errorf("exit = %v, want an OAuth2 token endpoint error", exit)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// bodyPattern is a pattern searched in the response body
+type bodyPattern struct {
+	expr string
+	re   *regexp.Regexp
+}
+
+// compilePatterns compiles the provided patterns, either as regular
+// expressions or as literal strings
+func compilePatterns(patterns []string, regex, ignoreCase bool) ([]bodyPattern, error) {
+	compiled := make([]bodyPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := pattern
+		if !regex {
+			expr = regexp.QuoteMeta(expr)
+		}
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern /%s/: %s", pattern, err)
+		}
+		compiled = append(compiled, bodyPattern{expr: pattern, re: re})
+	}
+
+	return compiled, nil
+}
+
+// count returns the number of non-overlapping occurrences of the pattern
+func (p bodyPattern) count(body []byte) int {
+	return len(p.re.FindAllIndex(body, -1))
+}
+
+func (p bodyPattern) String() string {
+	return "/" + p.expr + "/"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// perfData represents a performance data metric, rendered in the Nagios
+// format: 'label'=value[UOM];[warn];[crit];[min];[max]
+type perfData struct {
+	label string
+	value float64
+	// Unit of measurement, e.g. s, ms, B, KB or % (optional)
+	unit string
+	// Warning and critical thresholds, expressed as Nagios ranges (optional)
+	warning  string
+	critical string
+	// Min and max possible values (optional)
+	min string
+	max string
+}
+
+// String returns the Nagios representation of the performance data
+func (p perfData) String() string {
+	label := p.label
+	if strings.ContainsAny(label, " '=") {
+		label = "'" + strings.Replace(label, "'", "''", -1) + "'"
+	}
+
+	fields := []string{formatFloat(p.value) + p.unit, p.warning, p.critical, p.min, p.max}
+
+	// Trailing empty fields can be omitted
+	for len(fields) > 1 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+
+	return label + "=" + strings.Join(fields, ";")
+}
+
+// sanitizeLabel replaces the characters of a label that are not letters,
+// digits, _, . or -, so the label can be parsed by the metric backends
+func sanitizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("_.-", r):
+			return r
+		}
+		return '_'
+	}, label)
+}
+
+// formatFloat returns the representation of a value, threshold or boundary
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// withPerfData appends the metrics to the message of an exit, after the |
+// separator, which can then no longer be part of the message
+func withPerfData(err error, metrics []perfData) error {
+	exit, ok := err.(*plugin.Exit)
+	if !ok || len(metrics) == 0 {
+		return err
+	}
+
+	s := make([]string, len(metrics))
+	for i, p := range metrics {
+		s[i] = p.String()
+	}
+
+	return &plugin.Exit{
+		Msg:    fmt.Sprintf("%s | %s", strings.Replace(exit.Msg, "|", "/", -1), strings.Join(s, " ")),
+		Status: exit.Status,
+	}
+}
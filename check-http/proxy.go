@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// proxyFunc returns the function used by the transport to determine which
+// proxy, if any, must be used for a given request
+func (c *CheckHTTP) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	var proxy func(*http.Request) (*url.URL, error)
+
+	switch {
+	case c.proxy != "":
+		proxyURL, err := c.proxyURL()
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	case c.ignoreProxyEnv:
+		// Neither an explicit proxy nor the environment, connect directly
+		return nil, nil
+	default:
+		// Honor the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+		proxy = http.ProxyFromEnvironment
+	}
+
+	if len(c.noProxy) == 0 {
+		return proxy, nil
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(c.noProxy, req.URL) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// proxyURL parses the configured proxy URL and adds the proxy credentials to
+// it, if provided
+func (c *CheckHTTP) proxyURL() (*url.URL, error) {
+	proxy := c.proxy
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, &plugin.Exit{Msg: "invalid proxy URL: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, &plugin.Exit{
+			Msg:    fmt.Sprintf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme),
+			Status: plugin.Unknown,
+		}
+	}
+
+	if c.proxyUser != "" {
+		username, password := c.proxyUser, ""
+		if i := strings.Index(c.proxyUser, ":"); i >= 0 {
+			username, password = c.proxyUser[:i], c.proxyUser[i+1:]
+		}
+		u.User = url.UserPassword(username, password)
+	}
+
+	return u, nil
+}
+
+// bypassProxy returns whether the provided URL matches one of the entries of
+// the no-proxy list. Entries can be "*", a host name, a domain suffix (with or
+// without a leading dot), an IP address or a CIDR range, optionally followed
+// by a port
+func bypassProxy(noProxy []string, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		if u.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		// Extract the optional port of the entry
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if entryIP := net.ParseIP(strings.Trim(entry, "[]")); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyConnectError is returned when the proxy refuses a CONNECT request
+type proxyConnectError struct {
+	status string
+}
+
+func (e *proxyConnectError) Error() string {
+	return "proxy CONNECT failed: " + e.status
+}
+
+// checkProxyConnect is called by the transport with the response of the proxy
+// to a CONNECT request, so its failures can be told apart from the ones of the
+// origin server
+func checkProxyConnect(ctx context.Context, proxyURL *url.URL, req *http.Request, resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return &proxyConnectError{status: resp.Status}
+	}
+	return nil
+}
+
+// isProxyError returns whether the error occurred while connecting to the
+// proxy, as opposed to the origin server
+func isProxyError(err error) bool {
+	var connectErr *proxyConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "proxyconnect"
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+	"golang.org/x/net/publicsuffix"
+)
+
+// redirectError is returned when a redirection can not be followed
+type redirectError struct {
+	msg   string
+	chain []*http.Request
+}
+
+func (e *redirectError) Error() string {
+	return e.msg
+}
+
+// checkRedirect is called by the client before following a redirection, with
+// the next request and the ones already made, oldest first
+func (c *CheckHTTP) checkRedirect(req *http.Request, via []*http.Request) error {
+	if !c.followRedirects {
+		return http.ErrUseLastResponse
+	}
+
+	chain := append(via[:len(via):len(via)], req)
+	previous := via[len(via)-1]
+
+	for _, r := range via {
+		if r.URL.String() == req.URL.String() {
+			return &redirectError{msg: "redirect loop", chain: chain}
+		}
+	}
+
+	if len(via) > c.maxRedirects {
+		return &redirectError{msg: fmt.Sprintf("too many redirects, stopped after %d", c.maxRedirects), chain: chain}
+	}
+
+	if c.requireHTTPS && req.URL.Scheme != "https" {
+		return &redirectError{msg: "insecure redirect to " + req.URL.String(), chain: chain}
+	}
+
+	if !c.allowCrossDomain && !sameDomain(previous.URL.Hostname(), req.URL.Hostname()) {
+		return &redirectError{
+			msg:   fmt.Sprintf("cross-domain redirect from %s to %s", previous.URL.Hostname(), req.URL.Hostname()),
+			chain: chain,
+		}
+	}
+
+	return nil
+}
+
+// redirectExit converts an error returned by checkRedirect into an exit, or
+// returns nil for any other error
+func redirectExit(err error) *plugin.Exit {
+	var redirectErr *redirectError
+	if !errors.As(err, &redirectErr) {
+		return nil
+	}
+
+	return &plugin.Exit{
+		Msg:    fmt.Sprintf("%s: %s", redirectErr.msg, formatChain(redirectErr.chain)),
+		Status: plugin.Critical,
+	}
+}
+
+// sameDomain returns whether the hosts share the same registrable domain, e.g.
+// www.example.com and login.example.com, IP addresses and hosts without one
+// must be equal
+func sameDomain(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	if net.ParseIP(a) != nil || net.ParseIP(b) != nil {
+		return false
+	}
+	domainA, err := publicsuffix.EffectiveTLDPlusOne(a)
+	if err != nil {
+		return false
+	}
+	domainB, err := publicsuffix.EffectiveTLDPlusOne(b)
+	return err == nil && domainA == domainB
+}
+
+// redirectChain returns the requests that led to the response, oldest first
+func redirectChain(resp *http.Response) []*http.Request {
+	var chain []*http.Request
+	for req := resp.Request; req != nil; {
+		chain = append([]*http.Request{req}, chain...)
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	return chain
+}
+
+// formatChain returns the URLs of the chain, each followed by the status code
+// of the redirection it returned
+func formatChain(chain []*http.Request) string {
+	var hops []string
+	for i, req := range chain {
+		hop := req.URL.String()
+		if i+1 < len(chain) && chain[i+1].Response != nil {
+			hop += fmt.Sprintf(" (%d)", chain[i+1].Response.StatusCode)
+		}
+		hops = append(hops, hop)
+	}
+	return strings.Join(hops, " -> ")
+}
+
+// verifyRedirects verifies the chain of redirections that led to the response
+// and returns an exit listing every failure, or nil if the chain is as
+// expected
+func (c *CheckHTTP) verifyRedirects(resp *http.Response) error {
+	chain := redirectChain(resp)
+	if len(chain) == 0 {
+		return nil
+	}
+
+	r := &result{}
+
+	final := chain[len(chain)-1].URL
+	if c.expectURL != "" && final.String() != c.expectURL {
+		r.fail(plugin.Critical, "final URL is %s, expected %s", final, c.expectURL)
+	}
+
+	if c.requireHTTPS && final.Scheme != "https" {
+		r.fail(plugin.Critical, "final URL %s does not use HTTPS", final)
+	}
+
+	if c.expectLocation != "" {
+		// The first hop is either the redirection that was followed, or the
+		// response itself when redirections are not followed
+		first := resp
+		if len(chain) > 1 {
+			first = chain[1].Response
+		}
+
+		location := first.Header.Get("Location")
+		switch {
+		case location == "":
+			r.fail(plugin.Critical, "no redirection, expected Location %s", c.expectLocation)
+		case location != c.expectLocation:
+			// Also accept the absolute form of a relative location
+			if u, err := first.Location(); err != nil || u.String() != c.expectLocation {
+				r.fail(plugin.Critical, "first redirection to %s, expected %s", location, c.expectLocation)
+			}
+		}
+	}
+
+	if r.status == plugin.OK {
+		return nil
+	}
+
+	msg := strings.Join(r.failures, ", ")
+	if len(chain) > 1 {
+		msg += ": " + formatChain(chain)
+	}
+	return &plugin.Exit{Msg: msg, Status: r.status}
+}
+
+// describeRedirects adds the chain of redirections, if any, to the exit
+func describeRedirects(exit error, resp *http.Response) error {
+	chain := redirectChain(resp)
+	if len(chain) < 2 {
+		return exit
+	}
+
+	if exit, ok := exit.(*plugin.Exit); ok {
+		exit.Msg += ", redirected " + formatChain(chain)
+	}
+	return exit
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunRedirects(t *testing.T) {
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/downgrade" {
+			http.Redirect(w, r, "http://"+r.Host+"/final", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("secure"))
+	}))
+	defer secure.Close()
+
+	mux := http.NewServeMux()
+	redirect := func(path, target string, code int) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target, code)
+		})
+	}
+	redirect("/a", "/b", http.StatusMovedPermanently)
+	redirect("/b", "/final", http.StatusFound)
+	redirect("/loop1", "/loop2", http.StatusFound)
+	redirect("/loop2", "/loop1", http.StatusFound)
+	redirect("/secure", secure.URL+"/final", http.StatusMovedPermanently)
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("final"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// The server is also reachable under another domain
+	otherDomain := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	redirect("/cross", otherDomain+"/final", http.StatusFound)
+
+	type fields struct {
+		allowCrossDomain bool
+		expectLocation   string
+		expectURL        string
+		followRedirects  bool
+		maxRedirects     int
+		redirectOK       bool
+		requireHTTPS     bool
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		url        string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Redirection not followed",
+			url:        ts.URL + "/a",
+			wantStatus: plugin.Warning,
+			wantMsg:    "unexpected redirection",
+		},
+		{
+			name:       "Chain followed",
+			fields:     fields{followRedirects: true},
+			url:        ts.URL + "/a",
+			wantStatus: plugin.OK,
+			wantMsg:    "redirected " + ts.URL + "/a (301) -> " + ts.URL + "/b (302) -> " + ts.URL + "/final",
+		},
+		{
+			name:       "Expected final URL",
+			fields:     fields{followRedirects: true, expectURL: ts.URL + "/final"},
+			url:        ts.URL + "/a",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Unexpected final URL",
+			fields:     fields{followRedirects: true, expectURL: ts.URL + "/b"},
+			url:        ts.URL + "/a",
+			wantStatus: plugin.Critical,
+			wantMsg:    "final URL is " + ts.URL + "/final, expected " + ts.URL + "/b",
+		},
+		{
+			name:       "Expected relative location",
+			fields:     fields{redirectOK: true, expectLocation: ts.URL + "/b"},
+			url:        ts.URL + "/a",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Unexpected first location",
+			fields:     fields{followRedirects: true, expectLocation: "/final"},
+			url:        ts.URL + "/a",
+			wantStatus: plugin.Critical,
+			wantMsg:    "first redirection to /b, expected /final",
+		},
+		{
+			name:       "No redirection",
+			fields:     fields{expectLocation: "/final"},
+			url:        ts.URL + "/final",
+			wantStatus: plugin.Critical,
+			wantMsg:    "no redirection",
+		},
+		{
+			name:       "Redirect loop",
+			fields:     fields{followRedirects: true},
+			url:        ts.URL + "/loop1",
+			wantStatus: plugin.Critical,
+			wantMsg:    "redirect loop: " + ts.URL + "/loop1 (302) -> " + ts.URL + "/loop2 (302) -> " + ts.URL + "/loop1",
+		},
+		{
+			name:       "Too many redirects",
+			fields:     fields{followRedirects: true, maxRedirects: 1},
+			url:        ts.URL + "/a",
+			wantStatus: plugin.Critical,
+			wantMsg:    "too many redirects, stopped after 1",
+		},
+		{
+			name:       "Cross-domain redirect",
+			fields:     fields{followRedirects: true},
+			url:        ts.URL + "/cross",
+			wantStatus: plugin.Critical,
+			wantMsg:    "cross-domain redirect from 127.0.0.1 to localhost",
+		},
+		{
+			name:       "Cross-domain redirect allowed",
+			fields:     fields{followRedirects: true, allowCrossDomain: true, expectURL: otherDomain + "/final"},
+			url:        ts.URL + "/cross",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Upgrade to HTTPS",
+			fields:     fields{followRedirects: true, requireHTTPS: true},
+			url:        ts.URL + "/secure",
+			wantStatus: plugin.OK,
+			wantMsg:    "redirected " + ts.URL + "/secure (301) -> " + secure.URL + "/final",
+		},
+		{
+			name:       "Final URL without HTTPS",
+			fields:     fields{requireHTTPS: true},
+			url:        ts.URL + "/final",
+			wantStatus: plugin.Critical,
+			wantMsg:    "final URL " + ts.URL + "/final does not use HTTPS",
+		},
+		{
+			name:       "Downgrade to HTTP",
+			fields:     fields{followRedirects: true, requireHTTPS: true},
+			url:        secure.URL + "/downgrade",
+			wantStatus: plugin.Critical,
+			wantMsg:    "insecure redirect to " + strings.Replace(secure.URL, "https", "http", 1) + "/final",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxRedirects := tt.fields.maxRedirects
+			if maxRedirects == 0 {
+				maxRedirects = 10
+			}
+			c := &CheckHTTP{
+				allowCrossDomain: tt.fields.allowCrossDomain,
+				expectLocation:   tt.fields.expectLocation,
+				expectURL:        tt.fields.expectURL,
+				followRedirects:  tt.fields.followRedirects,
+				insecure:         true,
+				maxRedirects:     maxRedirects,
+				redirectOK:       tt.fields.redirectOK,
+				requireHTTPS:     tt.fields.requireHTTPS,
+				timeout:          time.Second,
+				url:              tt.url,
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestSameDomain(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "www.example.com", true},
+		{"www.example.com", "login.example.com", true},
+		{"WWW.Example.com", "login.example.COM", true},
+		{"www.example.com", "www.example.org", false},
+		{"shop.example.co.uk", "www.example.co.uk", true},
+		{"example.co.uk", "other.co.uk", false},
+		{"a.github.io", "b.github.io", false},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "127.0.0.2", false},
+		{"127.0.0.1", "localhost", false},
+		{"localhost", "localhost", true},
+	}
+	for _, tt := range tests {
+		if got := sameDomain(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameDomain(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// resolveOverride is a --resolve entry, the addresses used to connect to a
+// host and port instead of resolving the host
+type resolveOverride struct {
+	host      string
+	port      string
+	addresses []string
+}
+
+// matches returns whether the override applies to the host and port. The *
+// host matches any host
+func (o resolveOverride) matches(host, port string) bool {
+	return (o.host == "*" || strings.EqualFold(o.host, host)) && o.port == port
+}
+
+// connectOverride is a --connect-to entry, the host and port connected to
+// instead of another one. Empty parts match any host or port, or keep them
+type connectOverride struct {
+	fromHost string
+	fromPort string
+	toHost   string
+	toPort   string
+}
+
+// matches returns whether the override applies to the host and port
+func (o connectOverride) matches(host, port string) bool {
+	return (o.fromHost == "" || strings.EqualFold(o.fromHost, host)) && (o.fromPort == "" || o.fromPort == port)
+}
+
+// splitFields splits s on the colons that are not enclosed in brackets, so
+// the fields can be IPv6 addresses such as [::1]
+func splitFields(s string) []string {
+	var fields []string
+	start, bracketed := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			bracketed = true
+		case ']':
+			bracketed = false
+		case ':':
+			if !bracketed {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// unbracket removes the brackets around an IPv6 address
+func unbracket(s string) string {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// validPort returns whether s is a port number
+func validPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port > 0 && port <= 65535
+}
+
+// parseResolve parses a "HOST:PORT:ADDRESS[,ADDRESS...]" override
+func parseResolve(s string) (resolveOverride, error) {
+	invalid := fmt.Errorf("invalid --resolve %q, expected \"HOST:PORT:ADDRESS[,ADDRESS...]\"", s)
+
+	fields := splitFields(s)
+	if len(fields) != 3 || fields[0] == "" || !validPort(fields[1]) {
+		return resolveOverride{}, invalid
+	}
+
+	o := resolveOverride{host: unbracket(fields[0]), port: fields[1]}
+	for _, address := range strings.Split(fields[2], ",") {
+		address = unbracket(strings.TrimSpace(address))
+		if net.ParseIP(address) == nil {
+			return resolveOverride{}, fmt.Errorf("invalid --resolve %q, %q is not an IP address", s, address)
+		}
+		o.addresses = append(o.addresses, address)
+	}
+
+	return o, nil
+}
+
+// parseConnectTo parses a "HOST1:PORT1:HOST2:PORT2" override
+func parseConnectTo(s string) (connectOverride, error) {
+	fields := splitFields(s)
+	if len(fields) != 4 {
+		return connectOverride{}, fmt.Errorf("invalid --connect-to %q, expected \"HOST1:PORT1:HOST2:PORT2\"", s)
+	}
+	for _, port := range []string{fields[1], fields[3]} {
+		if port != "" && !validPort(port) {
+			return connectOverride{}, fmt.Errorf("invalid --connect-to %q, %q is not a port", s, port)
+		}
+	}
+
+	return connectOverride{
+		fromHost: unbracket(fields[0]),
+		fromPort: fields[1],
+		toHost:   unbracket(fields[2]),
+		toPort:   fields[3],
+	}, nil
+}
+
+// resolveOverrides parses the --resolve overrides
+func (c *CheckHTTP) resolveOverrides() ([]resolveOverride, error) {
+	overrides := make([]resolveOverride, 0, len(c.resolve))
+	for _, s := range c.resolve {
+		o, err := parseResolve(s)
+		if err != nil {
+			return nil, &plugin.Exit{Msg: err.Error(), Status: plugin.Unknown}
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+// overrideDial returns a dial function that applies the --connect-to
+// overrides, then the --resolve ones, to the address being dialed. Only the
+// connections are redirected, the requests still use the URL, hence its Host
+// header, SNI and certificate verification
+func (c *CheckHTTP) overrideDial(dial dialFunc) (dialFunc, error) {
+	resolves, err := c.resolveOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	connects := make([]connectOverride, 0, len(c.connectTo))
+	for _, s := range c.connectTo {
+		o, err := parseConnectTo(s)
+		if err != nil {
+			return nil, &plugin.Exit{Msg: err.Error(), Status: plugin.Unknown}
+		}
+		connects = append(connects, o)
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+
+		for _, o := range connects {
+			if o.matches(host, port) {
+				if o.toHost != "" {
+					host = o.toHost
+				}
+				if o.toPort != "" {
+					port = o.toPort
+				}
+				break
+			}
+		}
+
+		addrs := []string{net.JoinHostPort(host, port)}
+		for _, o := range resolves {
+			if o.matches(host, port) {
+				addrs = addrs[:0]
+				for _, address := range o.addresses {
+					addrs = append(addrs, net.JoinHostPort(address, port))
+				}
+				break
+			}
+		}
+
+		// Like a resolved host, try the addresses in order
+		var conn net.Conn
+		for _, addr := range addrs {
+			if conn, err = dial(ctx, network, addr); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}, nil
+}
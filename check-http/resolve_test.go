@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestParseResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    resolveOverride
+		wantErr string
+	}{
+		{
+			name: "Single address",
+			s:    "example.com:443:10.0.0.1",
+			want: resolveOverride{host: "example.com", port: "443", addresses: []string{"10.0.0.1"}},
+		},
+		{
+			name: "Several addresses",
+			s:    "*:80:10.0.0.1,[2001:db8::1]",
+			want: resolveOverride{host: "*", port: "80", addresses: []string{"10.0.0.1", "2001:db8::1"}},
+		},
+		{
+			name:    "Missing port",
+			s:       "example.com:10.0.0.1",
+			wantErr: `invalid --resolve "example.com:10.0.0.1", expected "HOST:PORT:ADDRESS[,ADDRESS...]"`,
+		},
+		{
+			name:    "Invalid port",
+			s:       "example.com:https:10.0.0.1",
+			wantErr: "expected \"HOST:PORT:ADDRESS[,ADDRESS...]\"",
+		},
+		{
+			name:    "Host name as address",
+			s:       "example.com:443:backend.example.com",
+			wantErr: `"backend.example.com" is not an IP address`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResolve(tt.s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseResolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseResolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConnectTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    connectOverride
+		wantErr string
+	}{
+		{
+			name: "Host and port",
+			s:    "example.com:443:green.example.com:8443",
+			want: connectOverride{fromHost: "example.com", fromPort: "443", toHost: "green.example.com", toPort: "8443"},
+		},
+		{
+			name: "Any host and port",
+			s:    "::[::1]:",
+			want: connectOverride{toHost: "::1"},
+		},
+		{
+			name:    "Missing field",
+			s:       "example.com:443:green.example.com",
+			wantErr: `invalid --connect-to "example.com:443:green.example.com", expected "HOST1:PORT1:HOST2:PORT2"`,
+		},
+		{
+			name:    "Invalid port",
+			s:       "example.com:443:green.example.com:99999",
+			wantErr: `"99999" is not a port`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConnectTo(tt.s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConnectTo() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseConnectTo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "Test CA")
+	caFile := writeTempFile(t, dir, "ca.pem", ca.certPEM)
+	cert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "service.test"},
+		DNSNames:    []string{"service.test"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, nil)
+
+	var mu sync.Mutex
+	var serverNames []string
+	config := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			serverNames = append(serverNames, hello.ServerName)
+			return nil, nil
+		},
+	}
+	ts := newTestTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// The request keeps the host of the URL
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if host != "service.test" {
+			w.WriteHeader(http.StatusMisdirectedRequest)
+		}
+	}, config, cert)
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	tests := []struct {
+		name       string
+		url        string
+		args       []string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Resolve",
+			url:        "https://service.test:" + port + "/",
+			args:       []string{"--resolve", "service.test:" + port + ":127.0.0.1"},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name:       "Resolve to several addresses",
+			url:        "https://service.test:" + port + "/",
+			args:       []string{"--resolve", "service.test:" + port + ":127.0.0.3,127.0.0.1"},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name:       "Resolve any host",
+			url:        "https://service.test:" + port + "/",
+			args:       []string{"--resolve", "other.test:" + port + ":127.0.0.3", "--resolve", "*:" + port + ":127.0.0.1"},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name:       "Connect to another host and port",
+			url:        "https://service.test/",
+			args:       []string{"--connect-to", "service.test:443:localhost:" + port},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name:       "Connect to any host and port",
+			url:        "https://service.test/",
+			args:       []string{"--connect-to", "::service.test:" + port, "--resolve", "service.test:" + port + ":127.0.0.1"},
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name:       "Every address of the override",
+			url:        "https://service.test:" + port + "/",
+			args:       []string{"--all-addresses", "--resolve", "service.test:" + port + ":127.0.0.1,127.0.0.3"},
+			wantStatus: plugin.Critical,
+			wantMsg:    "1/2 addresses OK, 1 critical\nOK: addr_127.0.0.1 127.0.0.1 - 200 OK",
+		},
+		{
+			name:       "Proxy",
+			url:        "https://service.test:" + port + "/",
+			args:       []string{"--resolve", "service.test:" + port + ":127.0.0.1", "--proxy", "http://127.0.0.1:3128"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "--connect-to and --resolve can not be used with --proxy",
+		},
+		{
+			name:       "Invalid override",
+			url:        "https://service.test/",
+			args:       []string{"--resolve", "service.test:127.0.0.1"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "invalid --resolve",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			serverNames = nil
+			mu.Unlock()
+
+			c := newCheckHTTP()
+			args := append([]string{"--cacert", caFile, "-u", tt.url}, tt.args...)
+			if err := c.cmd.ParseFlags(args); err != nil {
+				t.Fatal(err)
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, name := range serverNames {
+				if name != "service.test" {
+					t.Errorf("server name = %q, want \"service.test\"", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareClientOverrides(t *testing.T) {
+	// The proxies of the environment would connect to the host instead
+	c := &CheckHTTP{resolve: []string{"service.test:443:127.0.0.1"}}
+	client, err := c.prepareClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport := client.Transport.(*http.Transport); transport.Proxy != nil {
+		t.Error("the transport uses a proxy along with --resolve")
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// scenario is a synthetic transaction made of several requests, run in order
+type scenario struct {
+	Steps []scenarioStep `json:"steps"`
+}
+
+// scenarioStep is a request of a scenario, with its assertions and the values
+// it captures for the following steps. Its fields have the meaning of the
+// flags of the same name
+type scenarioStep struct {
+	Name                  string             `json:"name"`
+	Method                string             `json:"method"`
+	URL                   string             `json:"url"`
+	Headers               []string           `json:"headers"`
+	Data                  string             `json:"data"`
+	ContentType           string             `json:"content_type"`
+	Status                string             `json:"status"`
+	ResponseCode          int                `json:"response_code"`
+	RedirectOK            bool               `json:"redirect_ok"`
+	Query                 []string           `json:"query"`
+	NegQuery              []string           `json:"negquery"`
+	Regex                 bool               `json:"regex"`
+	IgnoreCase            bool               `json:"ignore_case"`
+	JSONPath              []string           `json:"jsonpath"`
+	Expect                []string           `json:"expect"`
+	JSONWarning           []string           `json:"json_warning"`
+	JSONCritical          []string           `json:"json_critical"`
+	ResponseHeader        []string           `json:"response_header"`
+	ResponseHeaderWarning []string           `json:"response_header_warning"`
+	Capture               map[string]capture `json:"capture"`
+}
+
+// capture extracts a value from a response, with the first group of a regular
+// expression matched against the body (or the whole match without group), a
+// JSON path or the name of a header
+type capture struct {
+	Regex    string `json:"regex"`
+	JSONPath string `json:"jsonpath"`
+	Header   string `json:"header"`
+}
+
+// templateVariable matches the {{name}} variables of the steps
+var templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// loadScenario reads a scenario file, in JSON. Unknown fields are rejected so
+// a typo can not silently disable an assertion
+func loadScenario(path string) (*scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &plugin.Exit{Msg: "could not read the scenario: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	var s scenario
+	if err := decoder.Decode(&s); err != nil {
+		return nil, &plugin.Exit{Msg: fmt.Sprintf("invalid scenario %s: %s", path, err), Status: plugin.Unknown}
+	}
+
+	if len(s.Steps) == 0 {
+		return nil, &plugin.Exit{Msg: fmt.Sprintf("invalid scenario %s: no steps", path), Status: plugin.Unknown}
+	}
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if step.URL == "" {
+			return nil, &plugin.Exit{Msg: fmt.Sprintf("invalid scenario %s: %s has no url", path, step.Name), Status: plugin.Unknown}
+		}
+		for name, c := range step.Capture {
+			sources := 0
+			for _, source := range []string{c.Regex, c.JSONPath, c.Header} {
+				if source != "" {
+					sources++
+				}
+			}
+			if sources != 1 {
+				return nil, &plugin.Exit{
+					Msg:    fmt.Sprintf("invalid scenario %s: capture %s of %s needs exactly one of regex, jsonpath or header", path, name, step.Name),
+					Status: plugin.Unknown,
+				}
+			}
+		}
+	}
+
+	return &s, nil
+}
+
+// expandVariables replaces the {{name}} variables of the string by the
+// captured values. Any other variable is an error
+func expandVariables(s string, vars map[string]string) (string, error) {
+	var err error
+	s = templateVariable.ReplaceAllStringFunc(s, func(variable string) string {
+		name := templateVariable.FindStringSubmatch(variable)[1]
+		value, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable %s", name)
+		}
+		return value
+	})
+	return s, err
+}
+
+// stepCheck returns a copy of the check that performs the step. The request
+// and the assertions are the ones of the step, while the connection settings
+// and the authentication are shared by every step
+func (c *CheckHTTP) stepCheck(step scenarioStep, vars map[string]string) (*CheckHTTP, error) {
+	var err error
+	expand := func(s string) string {
+		if err != nil {
+			return ""
+		}
+		s, err = expandVariables(s, vars)
+		return s
+	}
+	expandAll := func(values []string) []string {
+		expanded := make([]string, len(values))
+		for i, value := range values {
+			expanded[i] = expand(value)
+		}
+		return expanded
+	}
+
+	sc := *c
+
+	// The URL of a step can be relative to --url
+	sc.url = expand(step.URL)
+	if base, parseErr := url.Parse(c.url); c.url != "" && parseErr == nil {
+		if ref, parseErr := url.Parse(sc.url); parseErr == nil {
+			sc.url = base.ResolveReference(ref).String()
+		}
+	}
+
+	sc.method = step.Method
+	sc.data = expand(step.Data)
+	sc.dataFile = ""
+	sc.contentType = step.ContentType
+	sc.headers = append(c.headers[:len(c.headers):len(c.headers)], expandAll(step.Headers)...)
+
+	sc.statusMapping = nil
+	if step.Status != "" {
+		if setErr := sc.statusMapping.Set(step.Status); setErr != nil && err == nil {
+			err = setErr
+		}
+	}
+	sc.responseCode = step.ResponseCode
+	sc.redirectOK = step.RedirectOK
+
+	sc.patterns = expandAll(step.Query)
+	sc.missingPatterns = expandAll(step.NegQuery)
+	sc.regex = step.Regex
+	sc.ignoreCase = step.IgnoreCase
+	sc.jsonPaths = step.JSONPath
+	sc.jsonExpect = expandAll(step.Expect)
+	sc.jsonWarning = expandAll(step.JSONWarning)
+	sc.jsonCritical = expandAll(step.JSONCritical)
+	sc.headerCritical = expandAll(step.ResponseHeader)
+	sc.headerWarning = expandAll(step.ResponseHeaderWarning)
+
+	if err != nil {
+		return nil, err
+	}
+	if len(sc.jsonExpect) > 0 && len(sc.jsonExpect) != len(sc.jsonPaths) {
+		return nil, fmt.Errorf("expect must be provided once for every jsonpath")
+	}
+	return &sc, nil
+}
+
+// captureValues extracts the values captured by the step from the response
+func captureValues(step scenarioStep, resp *http.Response, body []byte, vars map[string]string) error {
+	for name, c := range step.Capture {
+		switch {
+		case c.Regex != "":
+			re, err := regexp.Compile(c.Regex)
+			if err != nil {
+				return fmt.Errorf("invalid regex of capture %s: %s", name, err)
+			}
+			match := re.FindSubmatch(body)
+			if match == nil {
+				return fmt.Errorf("capture %s: /%s/ not found", name, c.Regex)
+			}
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			vars[name] = string(value)
+		case c.JSONPath != "":
+			path, err := parseJSONPath(c.JSONPath)
+			if err != nil {
+				return fmt.Errorf("capture %s: %s", name, err)
+			}
+			doc, err := decodeJSON(body)
+			if err != nil {
+				return fmt.Errorf("capture %s: invalid JSON response: %s", name, err)
+			}
+			value, ok := path.lookup(doc)
+			if !ok {
+				return fmt.Errorf("capture %s: %s not found", name, path)
+			}
+			vars[name] = formatJSONValue(value, false)
+		case c.Header != "":
+			values, ok := resp.Header[http.CanonicalHeaderKey(c.Header)]
+			if !ok {
+				return fmt.Errorf("capture %s: header %s is missing", name, http.CanonicalHeaderKey(c.Header))
+			}
+			vars[name] = values[0]
+		}
+	}
+	return nil
+}
+
+// stepTiming is the duration of a step that was performed
+type stepTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// runScenario performs the steps in order, with the same client so cookies
+// are kept between them, and stops at the first critical or unknown step. The
+// warnings of the steps are carried to the final status
+func (c *CheckHTTP) runScenario(client *http.Client, s *scenario) error {
+	vars := map[string]string{}
+	var steps []stepTiming
+	var total time.Duration
+
+	fail := func(i int, exit *plugin.Exit) error {
+		exit.Msg = fmt.Sprintf("step %d/%d %q failed: %s (%s)", i+1, len(s.Steps), s.Steps[i].Name, exit.Msg, formatSteps(steps))
+		c.metrics = scenarioPerfData(total, steps, c.warningTime, c.criticalTime)
+		return exit
+	}
+
+	var details []string
+	status := plugin.OK
+	for i, step := range s.Steps {
+		sc, err := c.stepCheck(step, vars)
+		if err != nil {
+			return fail(i, &plugin.Exit{Msg: err.Error(), Status: plugin.Unknown})
+		}
+
+		resp, t, err := sc.initiateRequest(client)
+		if err != nil {
+			exit, ok := err.(*plugin.Exit)
+			if !ok {
+				exit = &plugin.Exit{Msg: err.Error(), Status: plugin.Critical}
+			}
+			return fail(i, exit)
+		}
+		steps = append(steps, stepTiming{name: step.Name, duration: t.total()})
+		total += t.total()
+
+		// Keep the body for the captures, as the assertions consume it
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		detail := statusLine(resp.StatusCode)
+		exit, ok := sc.handleResponse(resp).(*plugin.Exit)
+		if ok && exit.Status != plugin.OK {
+			if exit.Status != plugin.Warning {
+				return fail(i, exit)
+			}
+			status, detail = plugin.Warning, exit.Msg
+		}
+		if err := captureValues(step, resp, body, vars); err != nil {
+			return fail(i, &plugin.Exit{Msg: err.Error(), Status: plugin.Critical})
+		}
+		details = append(details, fmt.Sprintf("%s %s", step.Name, detail))
+	}
+
+	exit := &plugin.Exit{
+		Msg:    fmt.Sprintf("%d steps passed: %s (%s)", len(s.Steps), strings.Join(details, ", "), formatSteps(steps)),
+		Status: status,
+	}
+	c.metrics = scenarioPerfData(total, steps, c.warningTime, c.criticalTime)
+
+	// The response time thresholds apply to the whole scenario
+	switch {
+	case c.criticalTime > 0 && total > c.criticalTime:
+		exit.Status = plugin.Critical
+		exit.Msg += fmt.Sprintf(", scenario time %s exceeds %s", formatDuration(total), formatDuration(c.criticalTime))
+	case c.warningTime > 0 && total > c.warningTime:
+		exit.Status = plugin.Warning
+		exit.Msg += fmt.Sprintf(", scenario time %s exceeds %s", formatDuration(total), formatDuration(c.warningTime))
+	}
+
+	return exit
+}
+
+// formatSteps returns the duration of every step that was performed
+func formatSteps(steps []stepTiming) string {
+	s := make([]string, len(steps))
+	for i, step := range steps {
+		s[i] = fmt.Sprintf("%s %s", step.name, formatDuration(step.duration))
+	}
+	return strings.Join(s, ", ")
+}
+
+// scenarioPerfData returns the total time of the scenario and the time of
+// every step
+func scenarioPerfData(total time.Duration, steps []stepTiming, warning, critical time.Duration) []perfData {
+	metrics := []perfData{timePerfData("time", total, warning, critical)}
+	for _, step := range steps {
+		metrics = append(metrics, timePerfData("time_"+step.name, step.duration, 0, 0))
+	}
+	return metrics
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunScenario(t *testing.T) {
+	const csrfToken = "c5rf-t0k3n"
+	orders := map[string]bool{}
+
+	loggedIn := func(r *http.Request) bool {
+		cookie, err := r.Cookie("session")
+		return err == nil && cookie.Value == "s3ss10n"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3ss10n", Path: "/"})
+		http.Redirect(w, r, "/form", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		if !loggedIn(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `<form><input type="hidden" name="csrf" value="%s"></form>`, csrfToken)
+	})
+	mux.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+		if !loggedIn(r) || r.Header.Get("X-CSRF-Token") != csrfToken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		orders["42"] = true
+		w.Header().Set("Location", "/orders/42")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id": 42, "status": "pending"}`)
+	})
+	mux.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/orders/")
+		if !loggedIn(r) || !orders[id] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(orders, id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprintf(w, `{"id": %s, "status": "pending"}`, id)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The environment is never expanded in the steps
+	os.Setenv("CHECK_HTTP_TEST_SECRET", "42")
+	defer os.Unsetenv("CHECK_HTTP_TEST_SECRET")
+
+	steps := func(getOrder string, password string) string {
+		return `{"steps": [
+			{"name": "login", "method": "POST", "url": "/login", "data": "user=sensu&password=` + password + `", "status": "303=ok"},
+			{"name": "csrf", "url": "/form", "capture": {"csrf": {"regex": "name=\"csrf\" value=\"([^\"]+)\""}}},
+			{"name": "order", "method": "POST", "url": "/orders", "headers": ["X-CSRF-Token: {{csrf}}"],
+			 "data": "{\"item\": \"book\"}", "content_type": "application/json", "response_code": 201,
+			 "capture": {"id": {"jsonpath": "$.id"}, "location": {"header": "Location"}}},
+			` + getOrder + `,
+			{"name": "delete", "method": "DELETE", "url": "{{location}}", "status": "204=ok"}
+		]}`
+	}
+
+	tests := []struct {
+		name       string
+		scenario   string
+		args       []string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Transaction",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}", "jsonpath": ["$.id", "$.status"], "expect": ["{{id}}", "pending"]}`, "secret"),
+			wantStatus: plugin.OK,
+			wantMsg:    "5 steps passed: login 303 See Other, csrf 200 OK, order 201 Created, get 200 OK, delete 204 No Content (login ",
+		},
+		{
+			name:       "Failing assertion",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}", "jsonpath": ["$.status"], "expect": ["shipped"]}`, "secret"),
+			wantStatus: plugin.Critical,
+			wantMsg:    `step 4/5 "get" failed: 200 OK $.status is "pending", expected "shipped" in 31 bytes (login `,
+		},
+		{
+			name:       "Warning step",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}", "response_header_warning": ["ETag exists"]}`, "secret"),
+			wantStatus: plugin.Warning,
+			wantMsg:    "5 steps passed: login 303 See Other, csrf 200 OK, order 201 Created, get 200 OK header Etag is missing, delete 204 No Content (login ",
+		},
+		{
+			name:       "Failing request",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}"}`, "wrong"),
+			wantStatus: plugin.Critical,
+			wantMsg:    `step 1/5 "login" failed: 401 Unauthorized`,
+		},
+		{
+			name:       "Undefined variable",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{order_id}}"}`, "secret"),
+			wantStatus: plugin.Unknown,
+			wantMsg:    `step 4/5 "get" failed: undefined variable order_id`,
+		},
+		{
+			name:       "Environment variable",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{CHECK_HTTP_TEST_SECRET}}"}`, "secret"),
+			wantStatus: plugin.Unknown,
+			wantMsg:    `step 4/5 "get" failed: undefined variable CHECK_HTTP_TEST_SECRET`,
+		},
+		{
+			name:       "Missing capture",
+			scenario:   `{"steps": [{"url": "/form", "capture": {"csrf": {"regex": "csrf"}}}]}`,
+			wantStatus: plugin.Critical,
+			wantMsg:    `step 1/1 "step 1" failed: 401 Unauthorized`,
+		},
+		{
+			name:       "Capture not found",
+			scenario:   `{"steps": [{"url": "/orders/42", "status": "404=ok", "capture": {"id": {"header": "X-Order-Id"}}}]}`,
+			wantStatus: plugin.Critical,
+			wantMsg:    "capture id: header X-Order-Id is missing",
+		},
+		{
+			name:       "Assertion of a single response",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}"}`, "secret"),
+			args:       []string{"--max-size", "1KB"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "--max-size can not be used with --scenario",
+		},
+		{
+			name:       "Global body assertion",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}"}`, "secret"),
+			args:       []string{"--query", "MUST-BE-HERE"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "--query can not be used with --scenario",
+		},
+		{
+			name:       "Global JSON assertion",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}"}`, "secret"),
+			args:       []string{"--jsonpath", "$.x"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "--jsonpath can not be used with --scenario",
+		},
+		{
+			name:       "Global status mapping",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}"}`, "secret"),
+			args:       []string{"--status", "2xx=critical"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "--status can not be used with --scenario",
+		},
+		{
+			name:       "Global request body",
+			scenario:   steps(`{"name": "get", "url": "/orders/{{id}}"}`, "secret"),
+			args:       []string{"--method", "POST", "--data", "id=42"},
+			wantStatus: plugin.Unknown,
+			wantMsg:    "--method can not be used with --scenario",
+		},
+		{
+			name:       "Unknown field",
+			scenario:   `{"steps": [{"url": "/form", "querry": ["csrf"]}]}`,
+			wantStatus: plugin.Unknown,
+			wantMsg:    `unknown field "querry"`,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, fmt.Sprintf("scenario-%d.json", i))
+			if err := ioutil.WriteFile(file, []byte(tt.scenario), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c := newCheckHTTP()
+			args := append([]string{"--scenario", file, "--timeout", "1s", "--url", ts.URL}, tt.args...)
+			if err := c.cmd.ParseFlags(args); err != nil {
+				t.Fatal(err)
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestValidateScenarioGlobalAssertions(t *testing.T) {
+	// Every flag replaced by the fields of the steps is rejected, so it can
+	// not be silently ignored
+	tests := [][]string{
+		{"--method", "POST"},
+		{"--data", "id=42"},
+		{"--data-file", "-"},
+		{"--content-type", "application/json"},
+		{"--status", "2xx=critical"},
+		{"--response-code", "201"},
+		{"--redirect-ok"},
+		{"--query", "MUST-BE-HERE"},
+		{"--negquery", "error"},
+		{"--regex"},
+		{"--ignore-case"},
+		{"--jsonpath", "$.x"},
+		{"--jsonpath", "$.x", "--expect", "1"},
+		{"--json-warning", "$.x > 1"},
+		{"--json-critical", "$.x > 2"},
+		{"--response-header", "Server exists"},
+		{"--response-header-warning", "Server absent"},
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			c := newCheckHTTP()
+			if err := c.cmd.ParseFlags(append([]string{"--scenario", "scenario.json"}, args...)); err != nil {
+				t.Fatal(err)
+			}
+			err := c.validate()
+			verifyExitCode(t, err, plugin.Unknown)
+			if err != nil && !strings.Contains(err.Error(), args[0]+" can not be used with --scenario") {
+				t.Errorf("validate() = %q, want %s rejected", err, args[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// signingTime returns the time used to sign requests, which tests can
+// override to reproduce known signatures
+var signingTime = time.Now
+
+// awsCredentials are the credentials used to sign requests for AWS
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// loadAWSCredentials returns the credentials of the standard environment
+// variables or, when they are not set, of the profile of the shared
+// credentials file
+func (c *CheckHTTP) loadAWSCredentials() (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{
+			accessKeyID:     id,
+			secretAccessKey: secret,
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := c.awsProfile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	return readSharedCredentials(path, profile)
+}
+
+// readSharedCredentials reads the credentials of a profile from a shared
+// credentials file, in the INI format
+func readSharedCredentials(path, profile string) (awsCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer f.Close()
+
+	var creds awsCredentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if section != profile || i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "aws_access_key_id":
+			creds.accessKeyID = value
+		case "aws_secret_access_key":
+			creds.secretAccessKey = value
+		case "aws_session_token":
+			creds.sessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, err
+	}
+
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("no credentials for profile %q in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// awsRegionName returns the region of the signature, from the configuration
+// or the standard environment variables
+func (c *CheckHTTP) awsRegionName() string {
+	for _, region := range []string{c.awsRegion, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if region != "" {
+			return region
+		}
+	}
+	return ""
+}
+
+// signSigV4 signs the request with AWS Signature Version 4, in the
+// Authorization header. Every header of the request is signed
+func (c *CheckHTTP) signSigV4(req *http.Request) error {
+	creds, err := c.loadAWSCredentials()
+	if err != nil {
+		return &plugin.Exit{Msg: "could not load the AWS credentials: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	region := c.awsRegionName()
+	if region == "" {
+		return &plugin.Exit{Msg: "no AWS region, set --aws-region or AWS_REGION", Status: plugin.Unknown}
+	}
+
+	body, err := requestPayload(req)
+	if err != nil {
+		return &plugin.Exit{Msg: "could not sign the request: " + err.Error(), Status: plugin.Unknown}
+	}
+	payloadHash := sha256Hex(body)
+
+	t := signingTime().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+	if c.awsService == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	canonicalRequest, signedHeaders := canonicalSigV4Request(req, c.awsService, payloadHash)
+	scope := strings.Join([]string{t.Format("20060102"), region, c.awsService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{t.Format("20060102"), region, c.awsService, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalSigV4Request returns the canonical form of the request and the
+// list of its signed headers
+func canonicalSigV4Request(req *http.Request, service, payloadHash string) (string, string) {
+	// The segments of the path are encoded twice, except for S3
+	segments := strings.Split(req.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+		if service != "s3" {
+			segments[i] = awsEscape(segments[i])
+		}
+	}
+	path := strings.Join(segments, "/")
+	if path == "" {
+		path = "/"
+	}
+
+	var query []string
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(query)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	return strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(query, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+// awsEscape percent-encodes every byte but the unreserved characters of
+// RFC 3986, as required by AWS
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// setEnv sets environment variables for the duration of a test
+func setEnv(t *testing.T, env map[string]string) {
+	for name, value := range env {
+		previous, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestRunSigV4(t *testing.T) {
+	// Test vectors of the AWS Signature Version 4 test suite
+	const credential = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "
+	tests := []struct {
+		name              string
+		method            string
+		path              string
+		data              string
+		contentType       string
+		wantAuthorization string
+	}{
+		{
+			name:              "get-vanilla",
+			method:            http.MethodGet,
+			path:              "/",
+			wantAuthorization: credential + "SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:              "get-vanilla-query-order-key-case",
+			method:            http.MethodGet,
+			path:              "/?Param2=value2&Param1=value1",
+			wantAuthorization: credential + "SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:              "post-vanilla",
+			method:            http.MethodPost,
+			path:              "/",
+			wantAuthorization: credential + "SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:              "post-x-www-form-urlencoded",
+			method:            http.MethodPost,
+			path:              "/",
+			data:              "Param1=value1",
+			contentType:       "application/x-www-form-urlencoded",
+			wantAuthorization: credential + "SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		"AWS_SESSION_TOKEN":     "",
+	})
+	signingTime = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+	defer func() { signingTime = time.Now }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The stand-in server only accepts the expected signature
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != tt.wantAuthorization {
+					t.Errorf("Authorization = %q, want %q", got, tt.wantAuthorization)
+					w.WriteHeader(http.StatusForbidden)
+				}
+				if got := r.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+					t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+				}
+			}))
+			defer ts.Close()
+
+			c := &CheckHTTP{
+				awsRegion:   "us-east-1",
+				awsService:  "service",
+				contentType: tt.contentType,
+				data:        tt.data,
+				headers:     []string{"Host: example.amazonaws.com"},
+				method:      tt.method,
+				timeout:     time.Second,
+				url:         ts.URL + tt.path,
+			}
+			verifyExitCode(t, c.Run(), plugin.OK)
+		})
+	}
+}
+
+func TestLoadAWSCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "credentials")
+	err = ioutil.WriteFile(file, []byte(strings.Join([]string{
+		"[default]",
+		"aws_access_key_id = AKIDDEFAULT",
+		"aws_secret_access_key = default-secret",
+		"",
+		"# Monitoring account",
+		"[monitoring]",
+		"aws_access_key_id=AKIDMONITORING",
+		"aws_secret_access_key=monitoring-secret",
+		"aws_session_token=monitoring-token",
+	}, "\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		profile string
+		want    awsCredentials
+		wantErr bool
+	}{
+		{
+			name: "Environment",
+			env: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKIDENV",
+				"AWS_SECRET_ACCESS_KEY": "env-secret",
+				"AWS_SESSION_TOKEN":     "env-token",
+			},
+			want: awsCredentials{accessKeyID: "AKIDENV", secretAccessKey: "env-secret", sessionToken: "env-token"},
+		},
+		{
+			name: "Default profile",
+			want: awsCredentials{accessKeyID: "AKIDDEFAULT", secretAccessKey: "default-secret"},
+		},
+		{
+			name:    "Profile flag",
+			profile: "monitoring",
+			want:    awsCredentials{accessKeyID: "AKIDMONITORING", secretAccessKey: "monitoring-secret", sessionToken: "monitoring-token"},
+		},
+		{
+			name: "Profile environment variable",
+			env:  map[string]string{"AWS_PROFILE": "monitoring"},
+			want: awsCredentials{accessKeyID: "AKIDMONITORING", secretAccessKey: "monitoring-secret", sessionToken: "monitoring-token"},
+		},
+		{
+			name:    "Unknown profile",
+			profile: "missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, map[string]string{
+				"AWS_ACCESS_KEY_ID":           "",
+				"AWS_SECRET_ACCESS_KEY":       "",
+				"AWS_SESSION_TOKEN":           "",
+				"AWS_PROFILE":                 "",
+				"AWS_SHARED_CREDENTIALS_FILE": file,
+			})
+			setEnv(t, tt.env)
+
+			c := &CheckHTTP{awsProfile: tt.profile}
+			got, err := c.loadAWSCredentials()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadAWSCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("loadAWSCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
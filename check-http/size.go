@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// sizeUnits are the supported size suffixes, in binary multiples, longest
+// first so that "KB" is not mistaken for "B"
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// sizeValue is a flag value that accepts a number of bytes, optionally
+// followed by a unit such as KB, MB or GB
+type sizeValue int64
+
+func newSizeValue(p *int64, value int64) *sizeValue {
+	*p = value
+	return (*sizeValue)(p)
+}
+
+func (s *sizeValue) Set(v string) error {
+	number, multiplier := strings.ToUpper(strings.TrimSpace(v)), int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q, expected a number of bytes optionally followed by KB, MB or GB", v)
+	}
+	if n > math.MaxInt64/multiplier {
+		return fmt.Errorf("invalid size %q, too large", v)
+	}
+	*s = sizeValue(n * multiplier)
+	return nil
+}
+
+func (s *sizeValue) String() string {
+	return formatSize(int64(*s))
+}
+
+func (s *sizeValue) Type() string {
+	return "size"
+}
+
+// formatSize returns a human-readable size, using the largest unit that
+// represents it exactly
+func formatSize(n int64) string {
+	for _, unit := range sizeUnits[:3] {
+		if n >= unit.multiplier && n%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", n/unit.multiplier, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// verifySize compares the size of the response body against the minimum and
+// maximum sizes, and records every failure in the result
+func (c *CheckHTTP) verifySize(size int64, r *result) {
+	switch {
+	case c.minSize > 0 && size < c.minSize:
+		r.fail(plugin.Critical, "body size %s is below %s", formatSize(size), formatSize(c.minSize))
+	case c.warningMinSize > 0 && size < c.warningMinSize:
+		r.fail(plugin.Warning, "body size %s is below %s", formatSize(size), formatSize(c.warningMinSize))
+	}
+
+	switch {
+	case c.maxSize > 0 && size > c.maxSize:
+		r.fail(plugin.Critical, "body size %s is above %s", formatSize(size), formatSize(c.maxSize))
+	case c.warningMaxSize > 0 && size > c.warningMaxSize:
+		r.fail(plugin.Warning, "body size %s is above %s", formatSize(size), formatSize(c.warningMaxSize))
+	}
+}
+
+// hasSizeAssertions returns whether the size of the body must be verified
+func (c *CheckHTTP) hasSizeAssertions() bool {
+	return c.minSize > 0 || c.maxSize > 0 || c.warningMinSize > 0 || c.warningMaxSize > 0
+}
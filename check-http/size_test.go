@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestSizeValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "512", want: 512},
+		{value: "512B", want: 512},
+		{value: "10KB", want: 10 << 10},
+		{value: "1.5MB", wantErr: true},
+		{value: "32M", want: 32 << 20},
+		{value: "2 gb", want: 2 << 30},
+		{value: "-1", wantErr: true},
+		{value: "large", wantErr: true},
+		{value: "8589934592GB", wantErr: true},
+		{value: "8589934591GB", want: 8589934591 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var got int64
+			err := newSizeValue(&got, 0).Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sizeValue.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("sizeValue.Set() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := formatSize(3 << 20); got != "3MB" {
+		t.Errorf("formatSize() = %q, want %q", got, "3MB")
+	}
+	if got := formatSize(1500); got != "1500B" {
+		t.Errorf("formatSize() = %q, want %q", got, "1500B")
+	}
+}
+
+func TestVerifyBodySize(t *testing.T) {
+	type fields struct {
+		maxSize        int64
+		minSize        int64
+		warningMaxSize int64
+		warningMinSize int64
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		size       int
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Within the limits",
+			fields:     fields{minSize: 10, warningMinSize: 100, warningMaxSize: 1000, maxSize: 2000},
+			size:       500,
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK in 500 bytes",
+		},
+		{
+			name:       "Below the warning minimum",
+			fields:     fields{minSize: 10, warningMinSize: 100},
+			size:       50,
+			wantStatus: plugin.Warning,
+			wantMsg:    "body size 50B is below 100B",
+		},
+		{
+			name:       "Below the critical minimum",
+			fields:     fields{minSize: 10, warningMinSize: 100},
+			size:       0,
+			wantStatus: plugin.Critical,
+			wantMsg:    "body size 0B is below 10B",
+		},
+		{
+			name:       "Above the warning maximum",
+			fields:     fields{warningMaxSize: 1 << 10, maxSize: 2 << 10},
+			size:       1500,
+			wantStatus: plugin.Warning,
+			wantMsg:    "body size 1500B is above 1KB",
+		},
+		{
+			name:       "Above the critical maximum",
+			fields:     fields{warningMaxSize: 1 << 10, maxSize: 2 << 10},
+			size:       4 << 10,
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK body size is above 2KB, reading stopped",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				maxSize:        tt.fields.maxSize,
+				minSize:        tt.fields.minSize,
+				warningMaxSize: tt.fields.warningMaxSize,
+				warningMinSize: tt.fields.warningMinSize,
+			}
+			resp := &http.Response{
+				Body:       ioutil.NopCloser(bytes.NewReader(make([]byte, tt.size))),
+				StatusCode: http.StatusOK,
+			}
+
+			exit := c.verifyBody(resp, &result{})
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestInitiateRequestMaxBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{
+			name: "Body within the limit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(make([]byte, 1024))
+			},
+		},
+		{
+			name: "Content-Length above the limit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "1048576")
+				w.Write(make([]byte, 1<<20))
+			},
+			wantErr: true,
+		},
+		{
+			name:   "HEAD of a large resource",
+			method: http.MethodHead,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "1073741824")
+			},
+		},
+		{
+			name: "No content",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "1073741824")
+				w.WriteHeader(http.StatusNotModified)
+			},
+		},
+		{
+			name: "Endless body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				chunk := make([]byte, 4096)
+				for {
+					if _, err := w.Write(chunk); err != nil {
+						return
+					}
+					select {
+					case <-r.Context().Done():
+						return
+					default:
+					}
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+
+			c := &CheckHTTP{
+				maxBody: 64 << 10,
+				method:  tt.method,
+				timeout: 5 * time.Second,
+				url:     ts.URL,
+			}
+			client, err := c.prepareClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = c.initiateRequest(client)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+				}
+				return
+			}
+			verifyExitCode(t, err, plugin.Critical)
+			if err == nil || !strings.Contains(err.Error(), "exceeds the 64KB limit of --max-body") {
+				t.Errorf("CheckHTTP.initiateRequest() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestRunLargeBody(t *testing.T) {
+	artifact := make([]byte, 1<<20)
+	sum := sha256.Sum256(artifact)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/artifact", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(artifact)
+	})
+	mux.HandleFunc("/endless", func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 4096)
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			default:
+			}
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	type fields struct {
+		checksum string
+		maxBody  int64
+		maxSize  int64
+		minSize  int64
+		patterns []string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		path       string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Status only, without limit",
+			path:       "/artifact",
+			wantStatus: plugin.OK,
+			wantMsg:    "200 OK",
+		},
+		{
+			name:       "Checksum and size, without limit",
+			fields:     fields{checksum: hex.EncodeToString(sum[:]), minSize: 1 << 20},
+			path:       "/artifact",
+			wantStatus: plugin.OK,
+			wantMsg:    "sha256 digest matches in 1048576 bytes",
+		},
+		{
+			name:       "Checksum above the limit",
+			fields:     fields{checksum: hex.EncodeToString(sum[:]), maxBody: 64 << 10},
+			path:       "/artifact",
+			wantStatus: plugin.Critical,
+			wantMsg:    "exceeds the 64KB limit of --max-body",
+		},
+		{
+			name:       "Endless body without assertion",
+			fields:     fields{maxBody: 64 << 10},
+			path:       "/endless",
+			wantStatus: plugin.Critical,
+			wantMsg:    "exceeds the 64KB limit of --max-body",
+		},
+		{
+			name:       "Endless body with a maximum size",
+			fields:     fields{maxBody: 64 << 10, maxSize: 1 << 10},
+			path:       "/endless",
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK body size is above 1KB, reading stopped",
+		},
+		{
+			name:       "Endless body with a maximum size, without limit",
+			fields:     fields{maxSize: 1 << 10, patterns: []string{"healthy"}},
+			path:       "/endless",
+			wantStatus: plugin.Critical,
+			wantMsg:    "200 OK body size is above 1KB, reading stopped",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				checksum: tt.fields.checksum,
+				maxBody:  tt.fields.maxBody,
+				maxSize:  tt.fields.maxSize,
+				minSize:  tt.fields.minSize,
+				patterns: tt.fields.patterns,
+				timeout:  5 * time.Second,
+				url:      ts.URL + tt.path,
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// statusNames are the names of the plugin statuses in a status mapping
+var statusNames = map[string]int{
+	"ok":       plugin.OK,
+	"warning":  plugin.Warning,
+	"critical": plugin.Critical,
+	"unknown":  plugin.Unknown,
+}
+
+// statusRule maps a range of HTTP status codes to a plugin status
+type statusRule struct {
+	min, max int
+	status   int
+}
+
+// statusMapping is a flag value that maps HTTP status codes to plugin
+// statuses, provided as "codes=status" rules separated by semicolons, where
+// codes is a comma-separated list of codes (200), ranges (200-204) and classes
+// (5xx), e.g. "200-204,304=ok;429=warning;5xx=critical". The first matching
+// rule wins
+type statusMapping []statusRule
+
+func (m *statusMapping) Set(s string) error {
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid status rule %q, expected \"codes=status\"", rule)
+		}
+
+		name := strings.ToLower(strings.TrimSpace(rule[i+1:]))
+		status, ok := statusNames[name]
+		if !ok {
+			return fmt.Errorf("invalid status %q, expected ok, warning, critical or unknown", name)
+		}
+
+		for _, codes := range strings.Split(rule[:i], ",") {
+			min, max, err := parseStatusCodes(strings.TrimSpace(codes))
+			if err != nil {
+				return err
+			}
+			*m = append(*m, statusRule{min: min, max: max, status: status})
+		}
+	}
+
+	return nil
+}
+
+func (m *statusMapping) String() string {
+	var rules []string
+	for _, rule := range *m {
+		codes := strconv.Itoa(rule.min)
+		switch {
+		case rule.min%100 == 0 && rule.max == rule.min+99:
+			codes = strconv.Itoa(rule.min/100) + "xx"
+		case rule.max != rule.min:
+			codes += "-" + strconv.Itoa(rule.max)
+		}
+
+		for name, status := range statusNames {
+			if status == rule.status {
+				rules = append(rules, codes+"="+name)
+			}
+		}
+	}
+	return strings.Join(rules, ";")
+}
+
+func (m *statusMapping) Type() string {
+	return "mapping"
+}
+
+// lookup returns the status of the first rule matching the HTTP status code,
+// and whether a rule matched
+func (m statusMapping) lookup(code int) (int, bool) {
+	for _, rule := range m {
+		if code >= rule.min && code <= rule.max {
+			return rule.status, true
+		}
+	}
+	return plugin.Unknown, false
+}
+
+// parseStatusCodes parses a status code, a range of codes or a class of codes
+// and returns the lowest and the highest code it contains
+func parseStatusCodes(s string) (int, int, error) {
+	lower := strings.ToLower(s)
+	if len(lower) == 3 && strings.HasSuffix(lower, "xx") {
+		class, err := strconv.Atoi(lower[:1])
+		if err != nil || class < 1 || class > 5 {
+			return 0, 0, fmt.Errorf("invalid status class %q, expected 1xx to 5xx", s)
+		}
+		return class * 100, class*100 + 99, nil
+	}
+
+	bounds := strings.SplitN(s, "-", 2)
+	min, err := parseStatusCode(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(bounds) == 1 {
+		return min, min, nil
+	}
+
+	max, err := parseStatusCode(bounds[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if max < min {
+		return 0, 0, fmt.Errorf("invalid status range %q, %d is greater than %d", s, min, max)
+	}
+	return min, max, nil
+}
+
+// parseStatusCode parses a single HTTP status code
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("invalid status code %q, expected a number between 100 and 599", s)
+	}
+	return code, nil
+}
+
+// defaultStatusMapping returns the built-in mapping, used for the codes that
+// do not match --status: only the code of --response-code is OK when it is
+// not 200, otherwise 2xx is OK and 3xx is a warning unless --redirect-ok is
+// set. Every other code is critical
+func (c *CheckHTTP) defaultStatusMapping() statusMapping {
+	if c.responseCode != http.StatusOK && c.responseCode != 0 {
+		return statusMapping{{min: c.responseCode, max: c.responseCode, status: plugin.OK}}
+	}
+
+	redirection := plugin.Warning
+	if c.redirectOK {
+		redirection = plugin.OK
+	}
+	return statusMapping{
+		{min: 200, max: 299, status: plugin.OK},
+		{min: 300, max: 399, status: redirection},
+	}
+}
+
+// responseStatus returns the plugin status of the HTTP status code, according
+// to --status and then to the built-in mapping
+func (c *CheckHTTP) responseStatus(code int) int {
+	if status, ok := c.statusMapping.lookup(code); ok {
+		return status
+	}
+	if status, ok := c.defaultStatusMapping().lookup(code); ok {
+		return status
+	}
+	return plugin.Critical
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[int]int
+		wantErr bool
+	}{
+		{
+			name:  "Codes, ranges and classes",
+			value: "200-204,304=ok;429=warning;5xx=critical",
+			want: map[int]int{
+				200: plugin.OK,
+				204: plugin.OK,
+				304: plugin.OK,
+				429: plugin.Warning,
+				500: plugin.Critical,
+				599: plugin.Critical,
+				205: -1,
+				404: -1,
+			},
+		},
+		{
+			name:  "Case and spaces",
+			value: " 401 = OK ; 5XX = Unknown ",
+			want: map[int]int{
+				401: plugin.OK,
+				503: plugin.Unknown,
+			},
+		},
+		{
+			name:    "Missing status",
+			value:   "200",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown status",
+			value:   "200=fine",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid code",
+			value:   "600=ok",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid class",
+			value:   "6xx=ok",
+			wantErr: true,
+		},
+		{
+			name:    "Reversed range",
+			value:   "204-200=ok",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m statusMapping
+			err := m.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for code, want := range tt.want {
+				status, ok := m.lookup(code)
+				if !ok {
+					status = -1
+				}
+				if status != want {
+					t.Errorf("lookup(%d) = %d, want %d", code, status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusMappingString(t *testing.T) {
+	var m statusMapping
+	if err := m.Set("200-204,304=ok;5xx=critical"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "200-204=ok;304=ok;5xx=critical"
+	if got := m.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
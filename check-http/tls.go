@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// tlsConfig builds the TLS configuration of the client from the TLS flags
+func (c *CheckHTTP) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.insecure,
+		ServerName:         c.serverName,
+	}
+
+	if c.caCert != "" {
+		pool, err := loadCertPool(c.caCert)
+		if err != nil {
+			return nil, &plugin.Exit{Msg: "could not load CA certificates: " + err.Error(), Status: plugin.Unknown}
+		}
+		config.RootCAs = pool
+	}
+
+	if c.cert != "" {
+		cert, err := loadClientCertificate(c.cert, c.key, c.keyPassword)
+		if err != nil {
+			return nil, &plugin.Exit{Msg: "could not load client certificate: " + err.Error(), Status: plugin.Unknown}
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if c.key != "" {
+		return nil, &plugin.Exit{Msg: "--key requires --cert", Status: plugin.Unknown}
+	}
+
+	return config, nil
+}
+
+// loadCertPool returns a pool containing the PEM certificates found in the
+// provided file or, if it's a directory, in every file it contains
+func loadCertPool(path string) (*x509.CertPool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		files = nil
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	found := false
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if pool.AppendCertsFromPEM(data) {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// loadClientCertificate loads the client certificate used for mutual TLS. The
+// certificate can either be a PKCS#12 bundle or a PEM file, in which case the
+// private key is read from the key file or, if empty, the certificate file
+func loadClientCertificate(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	// Anything that is not PEM encoded is expected to be a PKCS#12 bundle
+	if block, _ := pem.Decode(certPEM); block == nil {
+		if keyFile != "" {
+			return tls.Certificate{}, errors.New("--key can not be used with a PKCS#12 bundle")
+		}
+		return loadPKCS12(certPEM, password)
+	}
+
+	keyPEM := certPEM
+	if keyFile != "" {
+		if keyPEM, err = ioutil.ReadFile(keyFile); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	keyPEM, err = decryptKey(keyPEM, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// decryptKey returns the PEM private key found in the provided data, decrypted
+// with the password if needed
+func decryptKey(data []byte, password string) ([]byte, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM private key found")
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+
+		// Encrypted PKCS#8, as emitted by OpenSSL 3 (e.g. PBES2 with PBKDF2
+		// and AES-256-CBC)
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			if password == "" {
+				return nil, errors.New("private key is encrypted, use --key-password")
+			}
+
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+			if err != nil {
+				return nil, fmt.Errorf("could not decrypt private key: %s", err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("could not decrypt private key: %s", err)
+			}
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+
+		// Legacy PEM encryption, as emitted by "openssl rsa -aes256"
+		if x509.IsEncryptedPEMBlock(block) {
+			if password == "" {
+				return nil, errors.New("private key is encrypted, use --key-password")
+			}
+
+			der, err := x509.DecryptPEMBlock(block, []byte(password))
+			if err != nil {
+				return nil, fmt.Errorf("could not decrypt private key: %s", err)
+			}
+			block = &pem.Block{Type: block.Type, Bytes: der}
+		}
+
+		return pem.EncodeToMemory(block), nil
+	}
+}
+
+// loadPKCS12 loads a certificate, its chain and its private key from a
+// PKCS#12 bundle, either legacy (RC2 or 3DES) or as emitted by OpenSSL 3
+// (PBES2 with AES and a SHA-256 MAC)
+func loadPKCS12(data []byte, password string) (tls.Certificate, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("invalid PKCS#12 bundle: %s", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("invalid PKCS#12 bundle: %s", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	for _, caCert := range caCerts {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})...)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// tlsError returns a precise exit for errors that occurred during the TLS
+// handshake, or nil if the error is not related to TLS
+func tlsError(err error) *plugin.Exit {
+	var msg string
+
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordHeaderErr tls.RecordHeaderError
+	var opErr *net.OpError
+	var verificationErr *tls.CertificateVerificationError
+
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		msg = "certificate signed by unknown authority"
+		if cert := unknownAuthorityErr.Cert; cert != nil {
+			msg += fmt.Sprintf(" (issuer %q)", cert.Issuer.String())
+		}
+	case errors.As(err, &hostnameErr):
+		msg = "hostname mismatch, " + hostnameErr.Error()
+	case errors.As(err, &invalidErr):
+		switch invalidErr.Reason {
+		case x509.Expired:
+			msg = "certificate has expired or is not yet valid"
+			if cert := invalidErr.Cert; cert != nil {
+				msg += fmt.Sprintf(" (valid from %s to %s)",
+					cert.NotBefore.UTC().Format("2006-01-02"), cert.NotAfter.UTC().Format("2006-01-02"))
+			}
+		default:
+			msg = "invalid certificate, " + invalidErr.Error()
+		}
+	case errors.As(err, &recordHeaderErr):
+		msg = "server did not respond with TLS"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// The server rejected the handshake, e.g. a missing client certificate
+		msg = "handshake rejected by the server, " + opErr.Err.Error()
+	case errors.As(err, &verificationErr):
+		msg = "certificate verification failed, " + verificationErr.Err.Error()
+	default:
+		return nil
+	}
+
+	return &plugin.Exit{Msg: "TLS error: " + msg, Status: plugin.Critical}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// testCert is a certificate and its private key, generated for the tests
+type testCert struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert generates a certificate from the template, signed by the parent
+// or self-signed if the parent is nil. A P-256 key is generated if key is nil
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert, key crypto.Signer) *testCert {
+	t.Helper()
+
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(365 * 24 * time.Hour)
+	}
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newTestCA generates a self-signed certificate authority
+func newTestCA(t *testing.T, name string) *testCert {
+	t.Helper()
+
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+}
+
+// newTestServerCert generates a server certificate valid for the local
+// addresses, signed by the provided CA
+func newTestServerCert(t *testing.T, ca *testCert, notAfter time.Time) *testCert {
+	t.Helper()
+
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, nil)
+}
+
+// newTestTLSServer starts a TLS server presenting the provided chain
+func newTestTLSServer(t *testing.T, handler http.HandlerFunc, config *tls.Config, chain ...*testCert) *httptest.Server {
+	t.Helper()
+
+	var cert tls.Certificate
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.cert.Raw)
+	}
+	cert.PrivateKey = chain[0].key
+
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.Certificates = []tls.Certificate{cert}
+
+	ts := httptest.NewUnstartedServer(handler)
+	ts.TLS = config
+	ts.StartTLS()
+	return ts
+}
+
+// writeTempFile writes the data to a new file of the directory
+func writeTempFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInitiateRequestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "Test CA")
+	caFile := writeTempFile(t, dir, "ca.pem", ca.certPEM)
+	caDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(caDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTempFile(t, caDir, "other.pem", newTestCA(t, "Other CA").certPEM)
+	writeTempFile(t, caDir, "ca.pem", ca.certPEM)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	valid := newTestTLSServer(t, handler, nil, newTestServerCert(t, ca, time.Time{}))
+	defer valid.Close()
+	expired := newTestTLSServer(t, handler, nil, newTestServerCert(t, ca, time.Now().Add(-time.Minute)))
+	defer expired.Close()
+
+	type fields struct {
+		caCert     string
+		insecure   bool
+		serverName string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		server  *httptest.Server
+		wantErr string
+	}{
+		{
+			name:    "Unknown authority",
+			server:  valid,
+			wantErr: "TLS error: certificate signed by unknown authority",
+		},
+		{
+			name:   "Insecure",
+			fields: fields{insecure: true},
+			server: valid,
+		},
+		{
+			name:   "CA file",
+			fields: fields{caCert: caFile},
+			server: valid,
+		},
+		{
+			name:   "CA directory",
+			fields: fields{caCert: caDir},
+			server: valid,
+		},
+		{
+			name:   "SNI override",
+			fields: fields{caCert: caFile, serverName: "localhost"},
+			server: valid,
+		},
+		{
+			name:    "Hostname mismatch",
+			fields:  fields{caCert: caFile, serverName: "wrong.example.com"},
+			server:  valid,
+			wantErr: "TLS error: hostname mismatch",
+		},
+		{
+			name:    "Expired certificate",
+			fields:  fields{caCert: caFile},
+			server:  expired,
+			wantErr: "TLS error: certificate has expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				caCert:     tt.fields.caCert,
+				insecure:   tt.fields.insecure,
+				serverName: tt.fields.serverName,
+				timeout:    time.Second,
+				url:        tt.server.URL,
+			}
+
+			client, err := c.prepareClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = c.initiateRequest(client)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+				}
+				return
+			}
+
+			verifyExitCode(t, err, plugin.Critical)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckHTTP.initiateRequest() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInitiateRequestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "check-http-client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+	certFile := writeTempFile(t, dir, "client.pem", client.certPEM)
+	keyFile := writeTempFile(t, dir, "client.key", client.keyPEM)
+	bundleFile := writeTempFile(t, dir, "bundle.pem", append(client.certPEM, client.keyPEM...))
+
+	ecKey, err := x509.MarshalECPrivateKey(client.key.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", ecKey, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedFile := writeTempFile(t, dir, "encrypted.key", pem.EncodeToMemory(encrypted))
+
+	// The server accepts any client certificate and reports its common name
+	ca := newTestCA(t, "Test CA")
+	ts := newTestTLSServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}, &tls.Config{ClientAuth: tls.RequireAnyClientCert}, newTestServerCert(t, ca, time.Time{}))
+	defer ts.Close()
+
+	type fields struct {
+		cert        string
+		key         string
+		keyPassword string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		wantClient string
+		wantStatus int
+		wantErr    string
+	}{
+		{
+			name:       "Missing client certificate",
+			wantStatus: plugin.Critical,
+			wantErr:    "TLS error: handshake rejected by the server",
+		},
+		{
+			name:       "PEM certificate and key",
+			fields:     fields{cert: certFile, key: keyFile},
+			wantClient: "check-http-client",
+		},
+		{
+			name:       "PEM bundle",
+			fields:     fields{cert: bundleFile},
+			wantClient: "check-http-client",
+		},
+		{
+			name:       "Encrypted key",
+			fields:     fields{cert: certFile, key: encryptedFile, keyPassword: "secret"},
+			wantClient: "check-http-client",
+		},
+		{
+			name:       "Encrypted key without password",
+			fields:     fields{cert: certFile, key: encryptedFile},
+			wantStatus: plugin.Unknown,
+			wantErr:    "private key is encrypted",
+		},
+		{
+			name:       "PKCS#12 bundle",
+			fields:     fields{cert: "testdata/client.p12", keyPassword: "secret"},
+			wantClient: "check-http-client",
+		},
+		{
+			name:       "Encrypted PKCS#8 key of OpenSSL 3",
+			fields:     fields{cert: "testdata/client-openssl3.pem", key: "testdata/client-openssl3.key", keyPassword: "secret"},
+			wantClient: "check-http-client",
+		},
+		{
+			name:       "Encrypted PKCS#8 key with wrong password",
+			fields:     fields{cert: "testdata/client-openssl3.pem", key: "testdata/client-openssl3.key", keyPassword: "wrong"},
+			wantStatus: plugin.Unknown,
+			wantErr:    "could not decrypt private key",
+		},
+		{
+			name:       "Encrypted PKCS#8 key without password",
+			fields:     fields{cert: "testdata/client-openssl3.pem", key: "testdata/client-openssl3.key"},
+			wantStatus: plugin.Unknown,
+			wantErr:    "private key is encrypted",
+		},
+		{
+			name:       "PKCS#12 bundle of OpenSSL 3",
+			fields:     fields{cert: "testdata/client-openssl3.p12", keyPassword: "secret"},
+			wantClient: "check-http-client",
+		},
+		{
+			name:       "PKCS#12 bundle with wrong password",
+			fields:     fields{cert: "testdata/client.p12", keyPassword: "wrong"},
+			wantStatus: plugin.Unknown,
+			wantErr:    "invalid PKCS#12 bundle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				cert:        tt.fields.cert,
+				insecure:    true,
+				key:         tt.fields.key,
+				keyPassword: tt.fields.keyPassword,
+				timeout:     time.Second,
+				url:         ts.URL,
+			}
+
+			client, err := c.prepareClient()
+			var resp *http.Response
+			if err == nil {
+				resp, _, err = c.initiateRequest(client)
+			}
+			if tt.wantErr != "" {
+				verifyExitCode(t, err, tt.wantStatus)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+			}
+			if got := resp.Header.Get("X-Client"); got != tt.wantClient {
+				t.Errorf("client certificate = %q, want %q", got, tt.wantClient)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phases are the names of the phases of a request, in chronological order
+var phases = []string{"dns", "connect", "tls", "server", "transfer"}
+
+// timings records when each phase of a request started and ended. After a
+// redirection, the phases are the ones of the final request, while the total
+// duration covers every request
+type timings struct {
+	// The hooks of the dialer can be called concurrently
+	mu sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	done         time.Time
+}
+
+// trace returns the hooks that record the timings of the request
+func (t *timings) trace() *httptrace.ClientTrace {
+	record := func(field *time.Time) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		*field = time.Now()
+	}
+
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// A new request starts, e.g. after a redirection, forget the
+			// phases of the previous one
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+			t.connectStart, t.connectDone = time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+			t.wroteRequest, t.firstByte = time.Time{}, time.Time{}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { record(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(&t.dnsDone) },
+		ConnectStart: func(network, addr string) {
+			// Only record the first attempt when several addresses are dialed
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone:          func(network, addr string, err error) { record(&t.connectDone) },
+		TLSHandshakeStart:    func() { record(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { record(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { record(&t.wroteRequest) },
+		GotFirstResponseByte: func() { record(&t.firstByte) },
+	}
+}
+
+// total returns the duration of the whole request
+func (t *timings) total() time.Duration {
+	return between(t.start, t.done)
+}
+
+// phase returns the duration of the named phase, or zero if the phase did not
+// happen, e.g. no TLS handshake for plain HTTP or no DNS lookup for an IP
+func (t *timings) phase(name string) time.Duration {
+	switch name {
+	case "dns":
+		return between(t.dnsStart, t.dnsDone)
+	case "connect":
+		return between(t.connectStart, t.connectDone)
+	case "tls":
+		return between(t.tlsStart, t.tlsDone)
+	case "server":
+		return between(t.wroteRequest, t.firstByte)
+	case "transfer":
+		return between(t.firstByte, t.done)
+	}
+	return 0
+}
+
+// String returns the duration of every phase that happened
+func (t *timings) String() string {
+	var s []string
+	for _, name := range phases {
+		if d := t.phase(name); d > 0 {
+			s = append(s, fmt.Sprintf("%s %s", name, formatDuration(d)))
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// between returns the duration between the two times, or zero if either is
+// unknown
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// phaseThresholds is a flag value that maps phases to duration thresholds,
+// provided as "phase=duration", e.g. "tls=1s"
+type phaseThresholds map[string]time.Duration
+
+func (p *phaseThresholds) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("invalid phase threshold %q, expected \"phase=duration\"", s)
+	}
+
+	name := strings.ToLower(strings.TrimSpace(s[:i]))
+	valid := false
+	for _, phase := range phases {
+		if phase == name {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unknown phase %q, expected one of %s", name, strings.Join(phases, ", "))
+	}
+
+	var d time.Duration
+	if err := newDurationValue(&d, 0).Set(strings.TrimSpace(s[i+1:])); err != nil {
+		return err
+	}
+
+	if *p == nil {
+		*p = phaseThresholds{}
+	}
+	(*p)[name] = d
+	return nil
+}
+
+func (p *phaseThresholds) String() string {
+	var s []string
+	for name, d := range *p {
+		s = append(s, name+"="+d.String())
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (p *phaseThresholds) Type() string {
+	return "phase=duration"
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestInitiateRequestTimings(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Think before answering, then send the body slowly
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(" world"))
+	}))
+	defer ts.Close()
+
+	c := &CheckHTTP{
+		insecure: true,
+		timeout:  time.Second,
+		url:      ts.URL,
+	}
+	client, err := c.prepareClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, timings, err := c.initiateRequest(client)
+	if err != nil {
+		t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+	}
+
+	if d := timings.phase("dns"); d != 0 {
+		t.Errorf("dns = %v, want 0 for an IP address", d)
+	}
+	for _, phase := range []string{"connect", "tls"} {
+		if d := timings.phase(phase); d <= 0 {
+			t.Errorf("%s = %v, want > 0", phase, d)
+		}
+	}
+	for _, phase := range []string{"server", "transfer"} {
+		if d := timings.phase(phase); d < 100*time.Millisecond {
+			t.Errorf("%s = %v, want >= 100ms", phase, d)
+		}
+	}
+	if total := timings.total(); total < 200*time.Millisecond {
+		t.Errorf("total = %v, want >= 200ms", total)
+	}
+}
+
+func TestInitiateRequestTimingsRedirect(t *testing.T) {
+	final := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer final.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		http.Redirect(w, r, final.URL, http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c := &CheckHTTP{
+		followRedirects: true,
+		maxRedirects:    10,
+		timeout:         time.Second,
+		url:             ts.URL,
+	}
+	client, err := c.prepareClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, timings, err := c.initiateRequest(client)
+	if err != nil {
+		t.Fatalf("CheckHTTP.initiateRequest() error = %v", err)
+	}
+
+	// The phases are the ones of the final request only
+	for _, phase := range phases {
+		if d := timings.phase(phase); d >= 100*time.Millisecond {
+			t.Errorf("%s = %v, want < 100ms", phase, d)
+		}
+	}
+	if d := timings.phase("connect"); d <= 0 {
+		t.Errorf("connect = %v, want > 0", d)
+	}
+	if total := timings.total(); total < 150*time.Millisecond {
+		t.Errorf("total = %v, want >= 150ms", total)
+	}
+}
+
+func TestRunPhaseThresholds(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		critical   string
+		warning    string
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name:       "Below the thresholds",
+			critical:   "server=2s",
+			warning:    "server=1s",
+			wantStatus: plugin.OK,
+		},
+		{
+			name:       "Warning threshold exceeded",
+			critical:   "server=1s",
+			warning:    "server=50ms",
+			wantStatus: plugin.Warning,
+			wantMsg:    "exceeds 50ms",
+		},
+		{
+			name:       "Critical threshold exceeded",
+			critical:   "server=50ms",
+			warning:    "connect=1s",
+			wantStatus: plugin.Critical,
+			wantMsg:    "exceeds 50ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CheckHTTP{
+				timeout: time.Second,
+				url:     ts.URL,
+			}
+			if err := c.phaseCritical.Set(tt.critical); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.phaseWarning.Set(tt.warning); err != nil {
+				t.Fatal(err)
+			}
+
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			if exit != nil && !strings.Contains(exit.Error(), tt.wantMsg) {
+				t.Errorf("exit = %q, want %q", exit.Error(), tt.wantMsg)
+			}
+			if exit != nil && !strings.Contains(exit.Error(), "(connect ") {
+				t.Errorf("exit = %q, want the phases", exit.Error())
+			}
+		})
+	}
+}
+
+func TestPhaseThresholds(t *testing.T) {
+	var p phaseThresholds
+	for _, s := range []string{"tls=1s", "server=500ms", "DNS = 2"} {
+		if err := p.Set(s); err != nil {
+			t.Fatalf("phaseThresholds.Set(%q) error = %v", s, err)
+		}
+	}
+	if got, want := p.String(), "dns=2s,server=500ms,tls=1s"; got != want {
+		t.Errorf("phaseThresholds.String() = %q, want %q", got, want)
+	}
+
+	for _, s := range []string{"tls", "body=1s", "tls=fast"} {
+		if err := p.Set(s); err == nil {
+			t.Errorf("phaseThresholds.Set(%q) error = nil, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+// loadURLs adds the URLs listed in --url-file to the ones of --url. Blank
+// lines and lines starting with # are ignored
+func (c *CheckHTTP) loadURLs() error {
+	if c.urlFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(c.urlFile)
+	if err != nil {
+		return &plugin.Exit{Msg: "could not read the URL file: " + err.Error(), Status: plugin.Unknown}
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c.urls = append(c.urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return &plugin.Exit{Msg: "could not read the URL file: " + err.Error(), Status: plugin.Unknown}
+	}
+
+	return nil
+}
+
+// checkURLs checks every URL with the same configuration and combines their
+// results
+func (c *CheckHTTP) checkURLs() error {
+	outcomes, err := c.checkConcurrently(len(c.urls), func(i int) outcome {
+		return c.checkCopy(func(uc *CheckHTTP) {
+			uc.url = c.urls[i]
+			uc.urls = nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	labels := make([]string, len(c.urls))
+	for i := range c.urls {
+		labels[i] = fmt.Sprintf("url%d", i+1)
+	}
+	return c.combineExits("URLs", c.urls, labels, outcomes)
+}
+
+// outcome is the result of a check and its metrics
+type outcome struct {
+	exit    *plugin.Exit
+	metrics []perfData
+}
+
+// checkConcurrently performs n checks, with at most --concurrency of them at
+// the same time, and returns their results in order
+func (c *CheckHTTP) checkConcurrently(n int, check func(i int) outcome) ([]outcome, error) {
+	// The standard input can only be read once for all the requests
+	if c.dataFile == "-" {
+		body, err := c.requestBody()
+		if err != nil {
+			return nil, err
+		}
+		c.data, c.dataFile = string(body), ""
+	}
+
+	// The checks share a single cookie jar, saved once they are all done, so
+	// their sessions do not overwrite each other in the file
+	jar, err := c.newCookieJar()
+	if err != nil {
+		return nil, err
+	}
+	c.jar = jar
+
+	workers := c.concurrency
+	if workers > n {
+		workers = n
+	}
+
+	outcomes := make([]outcome, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				outcomes[j] = check(j)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if jar != nil && c.cookieJar != "" {
+		jar.save(c.cookieJar)
+	}
+
+	return outcomes, nil
+}
+
+// checkCopy performs the check on a copy of the configuration, modified by
+// the provided function
+func (c *CheckHTTP) checkCopy(modify func(*CheckHTTP)) outcome {
+	cc := *c
+	cc.metrics = nil
+	modify(&cc)
+
+	err := cc.check()
+	if exit, ok := err.(*plugin.Exit); ok {
+		return outcome{exit: exit, metrics: cc.metrics}
+	}
+
+	msg := "check did not return an exit code"
+	if err != nil {
+		msg = err.Error()
+	}
+	return outcome{exit: &plugin.Exit{Msg: msg, Status: plugin.Unknown}, metrics: cc.metrics}
+}
+
+// combineExits reports the worst status of the checks or, when a quorum is
+// configured, grades the number of checks that failed. The message has a
+// summary line followed by one line per check, with its label and its name,
+// i.e. its URL or address, and the metrics of every check are prefixed by its
+// label
+func (c *CheckHTTP) combineExits(noun string, names, labels []string, outcomes []outcome) error {
+	counts := make([]int, len(plugin.Statuses))
+	worst := plugin.OK
+	lines := make([]string, len(outcomes))
+	var metrics []perfData
+	for i, o := range outcomes {
+		exit := o.exit
+		counts[exit.Status]++
+		if exit.Status > worst {
+			worst = exit.Status
+		}
+		lines[i] = fmt.Sprintf("%s: %s %s - %s", plugin.Statuses[exit.Status], labels[i], names[i], exit.Msg)
+
+		for _, p := range o.metrics {
+			p.label = labels[i] + "_" + p.label
+			metrics = append(metrics, p)
+		}
+	}
+
+	failed := len(outcomes) - counts[plugin.OK]
+	summary := []string{fmt.Sprintf("%d/%d %s OK", counts[plugin.OK], len(outcomes), noun)}
+	for status := plugin.Warning; status <= plugin.Unknown; status++ {
+		if counts[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[status], strings.ToLower(plugin.Statuses[status])))
+		}
+	}
+
+	status := worst
+	if c.warningFailures >= 0 || c.criticalFailures >= 0 {
+		switch {
+		case c.criticalFailures >= 0 && failed > c.criticalFailures:
+			status = plugin.Critical
+			summary = append(summary, fmt.Sprintf("more than %d failed", c.criticalFailures))
+		case c.warningFailures >= 0 && failed > c.warningFailures:
+			status = plugin.Warning
+			summary = append(summary, fmt.Sprintf("more than %d failed", c.warningFailures))
+		default:
+			status = plugin.OK
+		}
+	}
+
+	failedPerfData := perfData{
+		label: "failed",
+		value: float64(failed),
+		min:   "0",
+		max:   strconv.Itoa(len(outcomes)),
+	}
+	if c.warningFailures >= 0 {
+		failedPerfData.warning = strconv.Itoa(c.warningFailures)
+	}
+	if c.criticalFailures >= 0 {
+		failedPerfData.critical = strconv.Itoa(c.criticalFailures)
+	}
+	c.metrics = append([]perfData{failedPerfData}, metrics...)
+
+	return &plugin.Exit{
+		Msg:    strings.Join(summary, ", ") + "\n" + strings.Join(lines, "\n"),
+		Status: status,
+	}
+}
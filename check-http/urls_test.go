@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sensu-go-plugins/gunsen/plugin"
+)
+
+func TestRunURLs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("healthy"))
+		case "/slow":
+			w.Write([]byte("slow"))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	urlFile := filepath.Join(dir, "urls.txt")
+	list := "# Health checks\n" + ts.URL + "/ok\n\n  " + ts.URL + "/slow  \n"
+	if err := ioutil.WriteFile(urlFile, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStatus int
+		wantMsgs   []string
+	}{
+		{
+			name:       "All OK",
+			args:       []string{"-u", ts.URL + "/ok", "-u", ts.URL + "/slow"},
+			wantStatus: plugin.OK,
+			wantMsgs: []string{
+				"OK: 2/2 URLs OK\n",
+				"\nOK: url1 " + ts.URL + "/ok - 200 OK, response time ",
+				"\nOK: url2 " + ts.URL + "/slow - 200 OK, response time ",
+				"| failed=0;;;0;2 url1_time=",
+				" url2_status_code=200\n",
+			},
+		},
+		{
+			name:       "Worst status",
+			args:       []string{"-u", ts.URL + "/ok", "-u", ts.URL + "/down", "-q", "healthy"},
+			wantStatus: plugin.Critical,
+			wantMsgs: []string{
+				"CRITICAL: 1/2 URLs OK, 1 critical\n",
+				"\nOK: url1 " + ts.URL + "/ok - 200 OK found /healthy/",
+				"\nCRITICAL: url2 " + ts.URL + "/down - 500 Internal Server Error",
+			},
+		},
+		{
+			name:       "URL file",
+			args:       []string{"-u", ts.URL + "/down", "--url-file", urlFile},
+			wantStatus: plugin.Critical,
+			wantMsgs: []string{
+				"CRITICAL: 2/3 URLs OK, 1 critical\n",
+				"\nCRITICAL: url1 " + ts.URL + "/down",
+				"\nOK: url2 " + ts.URL + "/ok",
+				"\nOK: url3 " + ts.URL + "/slow",
+			},
+		},
+		{
+			name:       "Failures below the quorum",
+			args:       []string{"-u", ts.URL + "/ok", "-u", ts.URL + "/down", "--critical-failures", "1"},
+			wantStatus: plugin.OK,
+			wantMsgs:   []string{"OK: 1/2 URLs OK, 1 critical\n", "failed=1;;1;0;2"},
+		},
+		{
+			name:       "Failures above the critical quorum",
+			args:       []string{"-u", ts.URL + "/ok", "-u", ts.URL + "/down", "-u", ts.URL + "/gone", "--critical-failures", "1"},
+			wantStatus: plugin.Critical,
+			wantMsgs:   []string{"CRITICAL: 1/3 URLs OK, 2 critical, more than 1 failed\n"},
+		},
+		{
+			name:       "Failures above the warning quorum",
+			args:       []string{"-u", ts.URL + "/ok", "-u", ts.URL + "/down", "--warning-failures", "0", "--critical-failures", "1"},
+			wantStatus: plugin.Warning,
+			wantMsgs:   []string{"WARNING: 1/2 URLs OK, 1 critical, more than 0 failed\n", "failed=1;0;1;0;2"},
+		},
+		{
+			name:       "Scenario",
+			args:       []string{"-u", ts.URL + "/ok", "-u", ts.URL + "/slow", "--scenario", "scenario.json"},
+			wantStatus: plugin.Unknown,
+			wantMsgs:   []string{"--scenario can not be used with several URLs"},
+		},
+		{
+			name:       "Missing URL file",
+			args:       []string{"--url-file", filepath.Join(dir, "missing.txt")},
+			wantStatus: plugin.Unknown,
+			wantMsgs:   []string{"could not read the URL file"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCheckHTTP()
+			if err := c.cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			exit := c.Run()
+			verifyExitCode(t, exit, tt.wantStatus)
+			for _, msg := range tt.wantMsgs {
+				if exit != nil && !strings.Contains(exit.Error(), msg) {
+					t.Errorf("exit = %q, want %q", exit.Error(), msg)
+				}
+			}
+		})
+	}
+}
+
+func TestRunURLsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	c := newCheckHTTP()
+	args := []string{"--concurrency", "2"}
+	for i := 0; i < 6; i++ {
+		args = append(args, "-u", ts.URL)
+	}
+	if err := c.cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+
+	exit := c.Run()
+	verifyExitCode(t, exit, plugin.OK)
+	if maxInFlight != 2 {
+		t.Errorf("%d requests in flight, want 2", maxInFlight)
+	}
+}
+
+func TestRunURLsCookieJar(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "s3ss10n", Path: "/"})
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "check-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cookies.json")
+	c := newCheckHTTP()
+	args := []string{"--cookie-jar", file}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		args = append(args, "-u", ts.URL+"/"+name)
+	}
+	if err := c.cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	verifyExitCode(t, c.Run(), plugin.OK)
+
+	// The sessions of every URL are saved
+	jar, err := newPersistentJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jar.load(file); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(ts.URL)
+	if cookies := jar.Cookies(u); len(cookies) != 4 {
+		t.Errorf("saved %v, want the 4 cookies", cookies)
+	}
+}